@@ -0,0 +1,76 @@
+package datatemplate
+
+import (
+	"context"
+	"strings"
+
+	"github.com/demdxx/xtypes"
+	"github.com/pkg/errors"
+)
+
+var errCallDepthExceeded = errors.New("macro call depth exceeded")
+
+// Macro is the reusable template fragment with parameters defined by `$define`
+type Macro struct {
+	Name   string
+	Params []string
+	Body   Block
+}
+
+// CallBlock emits the macro body with arguments bound to the macro parameters
+type CallBlock struct {
+	blockSource
+	macro *Macro
+	args  []Block
+}
+
+// NewCallBlock creates new call of the macro, arguments are ordered as macro parameters
+func NewCallBlock(macro *Macro, args []Block) *CallBlock {
+	return &CallBlock{macro: macro, args: args}
+}
+
+func (b *CallBlock) String() string {
+	var buf strings.Builder
+	buf.WriteString("$call: {`$name`: " + b.macro.Name)
+	if len(b.args) > 0 {
+		buf.WriteString(", $args: {")
+		for i, arg := range b.args {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(b.macro.Params[i] + ": ")
+			if arg != nil {
+				buf.WriteString(arg.String())
+			}
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func (b *CallBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	if err := ctxCanceled(ctx); err != nil {
+		return nil, b.wrapError(err)
+	}
+	depth, _ := ctx.Value(ctxCallDepthKey).(int)
+	if depth >= ctxRenderState(ctx).maxCallDepth() {
+		return nil, b.wrapError(errors.Wrap(errCallDepthExceeded, b.macro.Name))
+	}
+	newData := xtypes.Map[string, any](data).Copy()
+	for i, arg := range b.args {
+		var val any
+		if arg != nil {
+			res, err := arg.Emit(ctx, data)
+			if err != nil {
+				return nil, b.wrapError(err)
+			}
+			val = plainValue(res)
+		}
+		newData[b.macro.Params[i]] = val
+	}
+	if b.macro.Body == nil {
+		return nil, nil
+	}
+	return b.macro.Body.Emit(context.WithValue(ctx, ctxCallDepthKey, depth+1), newData)
+}
@@ -0,0 +1,168 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallBlock(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader(`
+$define:
+  - name: port
+    params: [name, number]
+    body:
+      name: "{{name}}"
+      containerPort: "{{number}}"
+      app: "{{app}}"
+  - name: tree
+    params: [node]
+    body:
+      name: "{{node.name}}"
+      children:
+        $iterate: node.children
+        $body:
+          $call: tree
+          $args: ["{{item}}"]
+ports:
+  - $call: port
+    $args: [http, 80]
+  - $call: port
+    $args: {number: "{{https}}", name: https}
+tree:
+  $call: tree
+  $args: {node: "{{root}}"}
+`), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(tpl.String(), "{ports: [$call: {`$name`: port, $args: {name: http, number: 80}}, "+
+		"$call: {`$name`: port, $args: {name: https, number: `https`}}], tree: $call: {`$name`: tree, $args: {node: `root`}}}"), tpl.String())
+
+	res, err := tpl.Process(context.Background(), map[string]any{
+		"app":   "web",
+		"https": 443,
+		"root": map[string]any{"name": "a", "children": []any{
+			map[string]any{"name": "b", "children": []any{}},
+		}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	out := res.(*OrderedMap)
+	ports := out.Get("ports").([]any)
+	assert.Equal(t, map[string]any{"name": "http", "containerPort": 80, "app": "web"}, ports[0].(*OrderedMap).Map())
+	assert.Equal(t, map[string]any{"name": "https", "containerPort": 443, "app": "web"}, ports[1].(*OrderedMap).Map())
+	tree := out.Get("tree").(*OrderedMap)
+	assert.Equal(t, "a", tree.Get("name"))
+	assert.Equal(t, "b", tree.Get("children").([]any)[0].(*OrderedMap).Get("name"))
+}
+
+func TestCallBlockMapArgs(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader(`
+$define:
+  - name: show
+    params: [cfg]
+    body: "{{cfg.a}}-{{cfg.list[0].b}}-{{cfg.host}}"
+value:
+  $call: show
+  $args: {cfg: {a: 1, list: [{b: 2}], host: "{{host}}"}}
+`), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"host": "db"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1-2-db", res.(*OrderedMap).Get("value"))
+	}
+}
+
+func TestCallBlockDepth(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"$define": map[string]any{
+			"name": "loop",
+			"body": map[string]any{"$call": "loop"},
+		},
+		"value": map[string]any{"$call": "loop"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tpl.Process(context.Background(), nil)
+	var tplErr *TemplateError
+	if assert.ErrorIs(t, err, errCallDepthExceeded) && assert.True(t, errors.As(err, &tplErr)) {
+		assert.Equal(t, "/$define/body/$call", tplErr.Path)
+	}
+}
+
+func TestCallBlockDepthLimit(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"$define": map[string]any{
+			"name":   "count",
+			"params": []any{"n"},
+			"body": map[string]any{
+				"$if":   "n > 0",
+				"next":  map[string]any{"$call": "count", "$args": []any{"{{n - 1}}"}},
+				"$else": "done",
+			},
+		},
+		"value": map[string]any{"$call": "count", "$args": []any{"{{n}}"}},
+	}, WithLimits(Limits{MaxCallDepth: 5}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"n": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"value": map[string]any{"next": "done"}}, res)
+
+	_, err = tpl.Process(context.Background(), map[string]any{"n": 4})
+	assert.NoError(t, err)
+
+	_, err = tpl.Process(context.Background(), map[string]any{"n": 5})
+	assert.ErrorIs(t, err, errCallDepthExceeded)
+}
+
+func TestCallBlockErrors(t *testing.T) {
+	define := map[string]any{"name": "port", "params": []any{"name", "number"}, "body": "{{name}}:{{number}}"}
+	tests := []struct {
+		tpl  map[string]any
+		err  error
+		path string
+	}{
+		{
+			tpl:  map[string]any{"$define": define, "p": map[string]any{"$call": "prot", "$args": []any{"http", 80}}},
+			err:  errUnknownMacro,
+			path: "/p/$call",
+		},
+		{
+			tpl:  map[string]any{"$define": define, "p": map[string]any{"$call": "port", "$args": []any{"http"}}},
+			err:  errInvalidCallBlock,
+			path: "/p/$call",
+		},
+		{
+			tpl:  map[string]any{"$define": define, "p": map[string]any{"$call": "port", "$args": map[string]any{"name": "http", "num": 80}}},
+			err:  errInvalidCallBlock,
+			path: "/p/$args/num",
+		},
+		{
+			tpl:  map[string]any{"$define": []any{define, define}},
+			err:  errInvalidDefineBlock,
+			path: "/$define/1",
+		},
+		{
+			tpl:  map[string]any{"p": map[string]any{"$define": define}},
+			err:  errMisplacedDirective,
+			path: "/p/$define",
+		},
+	}
+	for _, test := range tests {
+		_, err := NewTemplateFor(test.tpl)
+		var tplErr *TemplateError
+		if assert.ErrorIs(t, err, test.err) && assert.True(t, errors.As(err, &tplErr)) {
+			assert.Equal(t, test.path, tplErr.Path)
+		}
+	}
+}
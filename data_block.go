@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/demdxx/gocast/v2"
 )
@@ -35,6 +36,7 @@ func (b *DataBlock) Emit(ctx context.Context, data map[string]any) (any, error)
 }
 
 type DataBlockSlice struct {
+	blockSource
 	data []any
 }
 
@@ -56,11 +58,26 @@ func (b *DataBlockSlice) String() string {
 }
 
 func (b *DataBlockSlice) Emit(ctx context.Context, data map[string]any) (any, error) {
+	if err := ctxCanceled(ctx); err != nil {
+		return nil, b.wrapError(err)
+	}
+	st := ctxRenderState(ctx)
+	if err := st.enter(); err != nil {
+		return nil, b.wrapError(err)
+	}
+	defer st.leave()
 	newResult := make([]any, 0, len(b.data))
 	for _, item := range b.data {
-		switch b := item.(type) {
+		count := len(newResult)
+		switch bl := item.(type) {
+		case *SpreadBlock:
+			res, err := bl.emitList(ctx, data)
+			if err != nil {
+				return nil, err
+			}
+			newResult = append(newResult, res...)
 		case Block:
-			res, err := b.Emit(ctx, data)
+			res, err := bl.Emit(ctx, data)
 			if err != nil {
 				return nil, err
 			}
@@ -68,48 +85,102 @@ func (b *DataBlockSlice) Emit(ctx context.Context, data map[string]any) (any, er
 		default:
 			newResult = append(newResult, item)
 		}
+		if err := st.addNodes(len(newResult) - count); err != nil {
+			return nil, b.wrapError(err)
+		}
 	}
 	return newResult, nil
 }
 
 type DataBlockMap struct {
+	blockSource
 	data map[string]any
+
+	// order of keys, if it's empty then keys are sorted
+	order []string
+
+	// ordered is true if the map emits *OrderedMap to preserve the order of keys
+	ordered bool
 }
 
 func (b *DataBlockMap) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("{")
-	i := 0
-	for key, item := range b.data {
+	for i, key := range b.keys() {
 		if i > 0 {
 			_, _ = buf.WriteString(", ")
 		}
 		_, _ = buf.WriteString(key)
 		_, _ = buf.WriteString(": ")
-		if sp, _ := item.(fmt.Stringer); sp != nil {
+		if sp, _ := b.data[key].(fmt.Stringer); sp != nil {
 			_, _ = buf.WriteString(sp.String())
 		} else {
-			_, _ = buf.WriteString(gocast.Str(item))
+			_, _ = buf.WriteString(gocast.Str(b.data[key]))
 		}
-		i++
 	}
 	buf.WriteString("}")
 	return buf.String()
 }
 
+// keys returns the list of map keys in the source order or sorted
+func (b *DataBlockMap) keys() []string {
+	if len(b.order) > 0 {
+		return b.order
+	}
+	keys := make([]string, 0, len(b.data))
+	for key := range b.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (b *DataBlockMap) Emit(ctx context.Context, data map[string]any) (any, error) {
-	newResult := make(map[string]any, len(b.data))
-	for key, item := range b.data {
-		switch b := item.(type) {
+	newResult := NewOrderedMap()
+	if err := ctxCanceled(ctx); err != nil {
+		return nil, b.wrapError(err)
+	}
+	st := ctxRenderState(ctx)
+	if err := st.enter(); err != nil {
+		return nil, b.wrapError(err)
+	}
+	defer st.leave()
+	for _, key := range b.keys() {
+		switch item := b.data[key].(type) {
+		case *SpreadBlock:
+			// Spread keys are placed at the position of the spread,
+			// explicitly defined fields have priority over spread ones
+			res, err := item.emitMap(ctx, data)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				continue
+			}
+			for _, key := range res.keys {
+				if _, ok := b.data[key]; !ok {
+					newResult.Set(key, res.values[key])
+					if err := st.addNodes(1); err != nil {
+						return nil, item.wrapError(err)
+					}
+				}
+			}
+			continue
 		case Block:
-			res, err := b.Emit(ctx, data)
+			res, err := item.Emit(ctx, data)
 			if err != nil {
 				return nil, err
 			}
-			newResult[key] = res
+			newResult.Set(key, res)
 		default:
-			newResult[key] = item
+			newResult.Set(key, item)
+		}
+		if err := st.addNodes(1); err != nil {
+			return nil, b.wrapError(err)
 		}
 	}
-	return newResult, nil
+	if b.ordered {
+		return newResult, nil
+	}
+	return newResult.values, nil
 }
@@ -0,0 +1,114 @@
+package datatemplate
+
+import (
+	"context"
+)
+
+// Directive is the template directive which is recognized by its key in the map, like `$if` or `$iterate`.
+// Custom directives are registered with the WithDirective option.
+//
+// Example:
+//
+//	secret := NewDirective("$secret", func(ctx context.Context, data *OrderedMap) (Block, error) {
+//		name, err := ParseBody(ctx, "$secret", data.Get("$secret"))
+//		if err != nil {
+//			return nil, err
+//		}
+//		return &SecretBlock{name: name, vault: vault}, nil
+//	})
+//	tpl, err := NewTemplateFor(data, WithDirective(secret))
+type Directive interface {
+	// Name of the directive key, like `$secret`
+	Name() string
+
+	// Parse the map which contains the directive key and return the block of the directive
+	Parse(ctx context.Context, data *OrderedMap) (Block, error)
+}
+
+type directiveFunc struct {
+	name    string
+	parse   func(ctx context.Context, data *OrderedMap) (Block, error)
+	builtin bool
+}
+
+// NewDirective creates new directive with the parse function
+func NewDirective(name string, parse func(ctx context.Context, data *OrderedMap) (Block, error)) Directive {
+	return &directiveFunc{name: name, parse: parse}
+}
+
+func (d *directiveFunc) Name() string {
+	return d.name
+}
+
+func (d *directiveFunc) Parse(ctx context.Context, data *OrderedMap) (Block, error) {
+	return d.parse(ctx, data)
+}
+
+// builtinDirectives returns directives of the module in the order of their priority
+func builtinDirectives() []Directive {
+	return []Directive{
+		&directiveFunc{name: "$if", parse: parseIfBlock, builtin: true},
+		&directiveFunc{name: "$iterate", parse: parseIteratorBlock, builtin: true},
+		&directiveFunc{name: "$include", parse: parseIncludeBlock, builtin: true},
+		&directiveFunc{name: "$with", parse: parseWithBlock, builtin: true},
+		&directiveFunc{name: "$let", parse: parseLetBlock, builtin: true},
+		&directiveFunc{name: "$switch", parse: parseSwitchBlock, builtin: true},
+		&directiveFunc{name: "$call", parse: parseCallBlock, builtin: true},
+	}
+}
+
+// mergeDirectives returns the list of builtin directives with custom ones,
+// the custom directive replaces the builtin one with the same name
+func mergeDirectives(custom []Directive) []Directive {
+	directives := builtinDirectives()
+	for _, directive := range custom {
+		replaced := false
+		for i, d := range directives {
+			if d.Name() == directive.Name() {
+				directives[i], replaced = directive, true
+				break
+			}
+		}
+		if !replaced {
+			directives = append(directives, directive)
+		}
+	}
+	return directives
+}
+
+// ctxDirectives returns directives of the template from the parse context
+func ctxDirectives(ctx context.Context) []Directive {
+	if directives := ctxGetOptions(ctx).directives; directives != nil {
+		return directives
+	}
+	return builtinDirectives()
+}
+
+// ParseBody parses the nested template data of the directive with the options of the template.
+// The key is the path of the data relative to the directive map and is used in errors,
+// the empty key means the directive map itself.
+func ParseBody(ctx context.Context, key string, data any) (Block, error) {
+	if key != "" {
+		ctx = ctxWithPath(ctx, key)
+	}
+	body, err := parseBlocks(ctx, data)
+	if err = parseError(ctx, err); err != nil {
+		return nil, err
+	}
+	return NewDataBlock(body), nil
+}
+
+// directiveBlock binds the block of the custom directive to its template path
+// to wrap errors of the processing
+type directiveBlock struct {
+	blockSource
+	Block
+}
+
+func (b *directiveBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	res, err := b.Block.Emit(ctx, data)
+	if err != nil {
+		return nil, b.wrapError(err)
+	}
+	return res, nil
+}
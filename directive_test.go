@@ -0,0 +1,103 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/demdxx/gocast/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestSecretNotFound = errors.New("secret not found")
+
+type testSecretBlock struct {
+	name    Block
+	secrets map[string]string
+}
+
+func (b *testSecretBlock) String() string {
+	return "$secret: " + b.name.String()
+}
+
+func (b *testSecretBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	name, err := b.name.Emit(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := b.secrets[gocast.Str(name)]
+	if !ok {
+		return nil, errTestSecretNotFound
+	}
+	return secret, nil
+}
+
+func testSecretDirective(secrets map[string]string) Directive {
+	return NewDirective("$secret", func(ctx context.Context, data *OrderedMap) (Block, error) {
+		name, err := ParseBody(ctx, "$secret", data.Get("$secret"))
+		if err != nil {
+			return nil, err
+		}
+		return &testSecretBlock{name: name, secrets: secrets}, nil
+	})
+}
+
+func TestDirective(t *testing.T) {
+	ctx := context.Background()
+	secret := testSecretDirective(map[string]string{"db/prod": "pa$$"})
+
+	tpl, err := NewTemplateFor(map[string]any{
+		"db": map[string]any{
+			"user":     "{{user}}",
+			"password": map[string]any{"$secret": "db/{{env}}"},
+		},
+	}, WithDirective(secret))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "{db: {password: $secret: db/{{env}}, user: `user`}}", tpl.String())
+
+	res, err := tpl.Process(ctx, map[string]any{"user": "admin", "env": "prod"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"db": map[string]any{"user": "admin", "password": "pa$$"}}, res)
+	}
+
+	_, err = tpl.Process(ctx, map[string]any{"user": "admin", "env": "dev"})
+	var tplErr *TemplateError
+	if assert.ErrorIs(t, err, errTestSecretNotFound) && assert.True(t, errors.As(err, &tplErr)) {
+		assert.Equal(t, "/db/password/$secret", tplErr.Path)
+	}
+
+	_, err = NewTemplateFor(map[string]any{"password": map[string]any{"$secret": "db/{{env +}}"}}, WithDirective(secret))
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "/password/$secret", tplErr.Path)
+	}
+
+	_, err = NewTemplateFor(map[string]any{"password": map[string]any{"$secrt": "db"}}, WithDirective(secret))
+	if assert.ErrorIs(t, err, errUnknownDirective) {
+		assert.Equal(t, "/password/$secrt: unknown directive $secrt, did you mean $secret?", err.Error())
+	}
+
+	// The directive is nested into the builtin one
+	tpl, err = NewTemplateFor(map[string]any{
+		"$iterate": "envs",
+		"$body":    map[string]any{"$secret": "db/{{item}}"},
+	}, WithDirective(secret))
+	if assert.NoError(t, err) {
+		res, err := tpl.Process(ctx, map[string]any{"envs": []any{"prod"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"pa$$"}, res)
+	}
+}
+
+func TestDirectiveReplaceBuiltin(t *testing.T) {
+	with := NewDirective("$with", func(ctx context.Context, data *OrderedMap) (Block, error) {
+		return ParseBody(ctx, "$with", data.Get("$with"))
+	})
+	tpl, err := NewTemplateFor(map[string]any{"$with": "{{name}}"}, WithDirective(with))
+	if assert.NoError(t, err) {
+		res, err := tpl.Process(context.Background(), map[string]any{"name": "web"})
+		assert.NoError(t, err)
+		assert.Equal(t, "web", res)
+	}
+}
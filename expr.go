@@ -11,6 +11,7 @@ import (
 var reExprExtract = regexp.MustCompile(`(?mU)(?:\{\{s=|\{\{)\s*(.+)\s*\}\}`)
 
 type ExprBlock struct {
+	blockSource
 	asStr bool
 	expr  *Program
 }
@@ -33,13 +34,22 @@ func (b *ExprBlock) String() string {
 
 func (b *ExprBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
 	res, err := runExpr(ctx, b.expr, data)
-	if err == nil && b.asStr {
+	if err != nil {
+		return nil, b.wrapError(err)
+	}
+	if b.asStr {
 		res = gocast.Str(res)
 	}
-	return res, err
+	if str, ok := res.(string); ok {
+		if err := ctxRenderState(ctx).checkStringLength(len(str)); err != nil {
+			return nil, b.wrapError(err)
+		}
+	}
+	return res, nil
 }
 
 type ExprBlockStringTmplate struct {
+	blockSource
 	expression string
 	exprs      map[string]*Program
 }
@@ -71,13 +81,27 @@ func (b *ExprBlockStringTmplate) String() string {
 }
 
 func (b *ExprBlockStringTmplate) Emit(ctx context.Context, data map[string]any) (any, error) {
-	result := b.expression
+	var (
+		st     = ctxRenderState(ctx)
+		result = b.expression
+		// The length of the result without placeholders which are not replaced yet
+		length = len(result)
+	)
+	for k := range b.exprs {
+		length -= strings.Count(result, k) * len(k)
+	}
 	for k, v := range b.exprs {
 		res, err := runExpr(ctx, v, data)
 		if err != nil {
-			return nil, err
+			return nil, b.wrapError(err)
+		}
+		str := gocast.Str(res)
+		// The length is checked before the replacement to not build the string over the limit
+		length += strings.Count(result, k) * len(str)
+		if err := st.checkStringLength(length); err != nil {
+			return nil, b.wrapError(err)
 		}
-		result = strings.ReplaceAll(result, k, gocast.Str(res))
+		result = strings.ReplaceAll(result, k, str)
 	}
 	return result, nil
 }
@@ -22,10 +22,43 @@ func ctxExprOptions(ctx context.Context) []expr.Option {
 
 type Program = vm.Program
 
+// exprCompileOptions returns expr options of the template including the standard functions
+func exprCompileOptions(ctx context.Context) []expr.Option {
+	opts := ctxExprOptions(ctx)
+	if stdOpts := ctxGetOptions(ctx).stdExprOptions(); len(stdOpts) > 0 {
+		// Standard functions go first, so the user options can override them
+		opts = append(stdOpts[:len(stdOpts):len(stdOpts)], opts...)
+	}
+	return opts
+}
+
 func compileExpr(ctx context.Context, expression string) (*Program, error) {
-	return expr.Compile(expression, ctxExprOptions(ctx)...)
+	opts := exprCompileOptions(ctx)
+	if scope := ctxSchema(ctx); scope != nil {
+		if _, err := scope.compile(expression, opts, ctxGetOptions(ctx).exprEnv); err != nil {
+			return nil, err
+		}
+		// Names of the data are checked by the schema, so the user environment doesn't have to declare them
+		opts = append(opts[:len(opts):len(opts)], expr.AllowUndefinedVariables())
+	}
+	policy := &ctxGetOptions(ctx).policy
+	if policy.empty() {
+		return expr.Compile(expression, opts...)
+	}
+	checker := &policyChecker{policy: policy}
+	program, err := expr.Compile(expression, append(opts[:len(opts):len(opts)], expr.Patch(checker))...)
+	if err != nil {
+		return nil, err
+	}
+	if checker.err != nil {
+		return nil, checker.err
+	}
+	return program, nil
 }
 
 func runExpr(ctx context.Context, program *Program, data map[string]any) (any, error) {
+	if err := ctxCanceled(ctx); err != nil {
+		return nil, err
+	}
 	return expr.Run(program, data)
 }
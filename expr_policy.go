@@ -0,0 +1,115 @@
+package datatemplate
+
+import (
+	"fmt"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/pkg/errors"
+)
+
+var (
+	errForbiddenFunc   = errors.New("forbidden function")
+	errForbiddenMember = errors.New("forbidden member")
+)
+
+// exprPolicy restricts functions and members available in template expressions
+type exprPolicy struct {
+	// allowedFuncs is the list of callable functions, nil means any function is allowed
+	allowedFuncs map[string]bool
+	deniedFuncs  map[string]bool
+
+	// allowedMembers is the list of accessible fields and methods of Go structs
+	// including the environment struct,
+	// nil means any member is allowed
+	allowedMembers map[string]bool
+}
+
+func (p *exprPolicy) empty() bool {
+	return p == nil || (p.allowedFuncs == nil && len(p.deniedFuncs) == 0 && p.allowedMembers == nil)
+}
+
+func (p *exprPolicy) checkFunc(name string) error {
+	if name == "" {
+		if p.allowedFuncs != nil {
+			return fmt.Errorf("%w call of the dynamic value", errForbiddenFunc)
+		}
+		return nil
+	}
+	if p.deniedFuncs[name] || (p.allowedFuncs != nil && !p.allowedFuncs[name]) {
+		return fmt.Errorf("%w %s", errForbiddenFunc, name)
+	}
+	return nil
+}
+
+func (p *exprPolicy) checkMember(name string) error {
+	if p.allowedMembers != nil && !p.allowedMembers[name] {
+		return fmt.Errorf("%w %s", errForbiddenMember, name)
+	}
+	return nil
+}
+
+// policyChecker walks the expression tree after the type check and records the first violation
+type policyChecker struct {
+	policy *exprPolicy
+	err    error
+}
+
+func (c *policyChecker) Visit(node *ast.Node) {
+	if c.err != nil {
+		return
+	}
+	switch n := (*node).(type) {
+	case *ast.CallNode:
+		c.err = c.policy.checkFunc(calleeName(n.Callee))
+	case *ast.BuiltinNode:
+		c.err = c.policy.checkFunc(n.Name)
+	case *ast.IdentifierNode:
+		// Fields and methods of the environment struct are accessible by name
+		if len(n.FieldIndex) > 0 || n.Method {
+			c.err = c.policy.checkMember(n.Value)
+		}
+	case *ast.MemberNode:
+		// Indexes are set by the type checker only for fields and methods of Go structs
+		if len(n.FieldIndex) > 0 || n.Method {
+			c.err = c.policy.checkMember(memberName(n))
+		} else if isEnvNode(n.Node) && memberName(n) == "" && c.policy.allowedMembers != nil {
+			c.err = fmt.Errorf("%w of the dynamic value", errForbiddenMember)
+		}
+	}
+}
+
+func memberName(n *ast.MemberNode) string {
+	if n.Name != "" {
+		return n.Name
+	}
+	if prop, ok := n.Property.(*ast.StringNode); ok {
+		return prop.Value
+	}
+	return ""
+}
+
+func isEnvNode(node ast.Node) bool {
+	ident, ok := node.(*ast.IdentifierNode)
+	return ok && ident.Value == "$env"
+}
+
+// calleeName returns the name of the called function or method, or empty string for dynamic calls
+func calleeName(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		return n.Value
+	case *ast.MemberNode:
+		return memberName(n)
+	}
+	return ""
+}
+
+func stringSet(names []string, set map[string]bool) map[string]bool {
+	if set == nil {
+		set = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,126 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPolicyEnv struct {
+	Name   string
+	Secret string
+	Items  []int
+}
+
+func (e testPolicyEnv) Upper(s string) string { return s }
+
+func (e testPolicyEnv) Exec(cmd string) string { return cmd }
+
+func TestExprPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		tpl  map[string]any
+		opts []Option
+		err  error
+		msg  string
+		path string
+	}{
+		{
+			name: "allowed",
+			tpl:  map[string]any{"name": "{{Upper(Name)}}", "count": "{{len(Items)}}"},
+			opts: []Option{WithAllowedFuncs("Upper", "len"), WithAllowedMembers("Name", "Items", "Upper")},
+		},
+		{
+			name: "not allowed method",
+			tpl:  map[string]any{"out": map[string]any{"$if": "true", "cmd": "{{Exec('ls')}}"}},
+			opts: []Option{WithAllowedFuncs("Upper")},
+			err:  errForbiddenFunc,
+			msg:  "forbidden function Exec",
+			path: "/out/cmd",
+		},
+		{
+			name: "not allowed builtin",
+			tpl:  map[string]any{"items": map[string]any{"$iterate": "filter(Items, # > 1)", "$body": "x"}},
+			opts: []Option{WithAllowedFuncs("Upper")},
+			err:  errForbiddenFunc,
+			msg:  "forbidden function filter",
+			path: "/items/$iterate",
+		},
+		{
+			name: "denied",
+			tpl:  map[string]any{"cmd": "{{Name | Exec()}}"},
+			opts: []Option{WithDeniedFuncs("Exec")},
+			err:  errForbiddenFunc,
+			msg:  "forbidden function Exec",
+			path: "/cmd",
+		},
+		{
+			name: "member",
+			tpl:  map[string]any{"list": []any{"{{Name}}", "{{$env.Secret}}"}},
+			opts: []Option{WithAllowedMembers("Name")},
+			err:  errForbiddenMember,
+			msg:  "forbidden member Secret",
+			path: "/list/1",
+		},
+		{
+			name: "env field",
+			tpl:  map[string]any{"secret": "{{Secret}}"},
+			opts: []Option{WithAllowedMembers("Name")},
+			err:  errForbiddenMember,
+			msg:  "forbidden member Secret",
+			path: "/secret",
+		},
+		{
+			name: "dynamic env member",
+			tpl:  map[string]any{"secret": "{{$env['Sec' + 'ret']}}"},
+			opts: []Option{WithAllowedMembers("Name")},
+			err:  errForbiddenMember,
+			path: "/secret",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTemplateFor(test.tpl, append(test.opts, WithExprEnv(testPolicyEnv{}))...)
+			if test.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var tplErr *TemplateError
+			if assert.ErrorIs(t, err, test.err) && assert.True(t, errors.As(err, &tplErr)) {
+				assert.Equal(t, test.path, tplErr.Path)
+				assert.Contains(t, err.Error(), test.msg)
+			}
+		})
+	}
+}
+
+func TestExprPolicyData(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{"name": "{{person.name}}", "age": "{{person.getAge()}}"},
+		WithAllowedFuncs("getAge"), WithAllowedMembers())
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{
+		"person": map[string]any{"name": "tony", "getAge": func() int { return 42 }},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"name": "tony", "age": 42}, res)
+	}
+
+	_, err = NewTemplateFor(map[string]any{"name": "{{person.getName()}}"}, WithAllowedFuncs("getAge"))
+	assert.ErrorIs(t, err, errForbiddenFunc)
+}
+
+func TestExprPolicyDynamicMember(t *testing.T) {
+	// Members of data values are unknown at the compile time and are not checked
+	tpl, err := NewTemplateFor(map[string]any{"secret": "{{user.Secret}}"}, WithAllowedMembers("Name"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"user": testPolicyEnv{Secret: "token"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"secret": "token"}, res)
+	}
+}
@@ -0,0 +1,206 @@
+package datatemplate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/ast"
+	"github.com/demdxx/gocast/v2"
+	"github.com/pkg/errors"
+)
+
+// ctxDataKey is the data key of the processing context for functions with context.Context argument
+const ctxDataKey = "$ctx"
+
+var (
+	errInvalidFunc = errors.New("invalid function")
+
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type namedFunc struct {
+	name string
+	fn   any
+}
+
+// exprFunc converts the Go function into expr function option.
+// The function returns one value or the value and error, the signature is checked at the compile time.
+// If the first argument is context.Context then it's not the argument of the expression
+// and it receives the context of the processing.
+func exprFunc(name string, fn any) (opt expr.Option, withCtx bool, err error) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func {
+		return nil, false, fmt.Errorf("%w %s: %s is not a function", errInvalidFunc, name, fnType)
+	}
+	switch {
+	case fnType.NumOut() == 1 && fnType.Out(0) != errorType:
+	case fnType.NumOut() == 2 && fnType.Out(1) == errorType:
+	default:
+		return nil, false, fmt.Errorf("%w %s: function must return the value or the value and error", errInvalidFunc, name)
+	}
+	withCtx = fnType.NumIn() > 0 && fnType.In(0) == contextType
+	call := func(params ...any) (any, error) {
+		args := make([]reflect.Value, len(params))
+		for i, param := range params {
+			argType := funcArgType(fnType, i)
+			switch {
+			case i == 0 && withCtx:
+				ctx, _ := param.(context.Context)
+				if ctx == nil {
+					ctx = context.Background()
+				}
+				args[i] = reflect.ValueOf(&ctx).Elem()
+			case param == nil:
+				args[i] = reflect.Zero(argType)
+			default:
+				// Context is not the argument of the expression
+				num := i + 1
+				if withCtx {
+					num = i
+				}
+				arg, err := funcArg(param, argType)
+				if err != nil {
+					return nil, fmt.Errorf("%s: argument %d %w", name, num, err)
+				}
+				args[i] = arg
+			}
+		}
+		out := fnValue.Call(args)
+		if len(out) == 2 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return out[0].Interface(), nil
+	}
+	return expr.Function(name, call, fn), withCtx, nil
+}
+
+// funcArg converts the value of the expression into the argument of the Go function,
+// numbers are converted only if the value is preserved and scalars are formatted as strings
+func funcArg(param any, argType reflect.Type) (reflect.Value, error) {
+	arg := reflect.ValueOf(param)
+	if arg.Type().AssignableTo(argType) {
+		return arg, nil
+	}
+	switch argType.Kind() {
+	case reflect.String:
+		if s, err := stdStrArg("", []any{param}, 0); err == nil {
+			return reflect.ValueOf(s).Convert(argType), nil
+		}
+		return arg, fmt.Errorf("must be a string, got %T", param)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		res := reflect.New(argType).Elem()
+		if num, err := gocast.TryNumber[float64](param); err == nil && num == float64(int64(num)) && !res.OverflowInt(int64(num)) {
+			res.SetInt(int64(num))
+			return res, nil
+		}
+		return arg, fmt.Errorf("must be an integer, got %T", param)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		res := reflect.New(argType).Elem()
+		if num, err := gocast.TryNumber[float64](param); err == nil && num >= 0 && num == float64(uint64(num)) && !res.OverflowUint(uint64(num)) {
+			res.SetUint(uint64(num))
+			return res, nil
+		}
+		return arg, fmt.Errorf("must be a non-negative integer, got %T", param)
+	case reflect.Float32, reflect.Float64:
+		if num, err := gocast.TryNumber[float64](param); err == nil {
+			return reflect.ValueOf(num).Convert(argType), nil
+		}
+		return arg, fmt.Errorf("must be a number, got %T", param)
+	case reflect.Bool:
+		if arg.Kind() == reflect.Bool {
+			return arg.Convert(argType), nil
+		}
+		return arg, fmt.Errorf("must be a bool, got %T", param)
+	case reflect.Slice:
+		// Items of the list like []any are converted one by one
+		if arg.Kind() == reflect.Slice || arg.Kind() == reflect.Array {
+			res := reflect.MakeSlice(argType, arg.Len(), arg.Len())
+			for i := 0; i < arg.Len(); i++ {
+				item := arg.Index(i).Interface()
+				if item == nil {
+					continue
+				}
+				val, err := funcArg(item, argType.Elem())
+				if err != nil {
+					return arg, fmt.Errorf("must be %s, got %T", argType, param)
+				}
+				res.Index(i).Set(val)
+			}
+			return res, nil
+		}
+	}
+	if arg.Kind() == argType.Kind() && arg.CanConvert(argType) {
+		return arg.Convert(argType), nil
+	}
+	return arg, fmt.Errorf("must be %s, got %T", argType, param)
+}
+
+// funcArgType returns the type of the i-th argument including variadic ones
+func funcArgType(fnType reflect.Type, i int) reflect.Type {
+	if fnType.IsVariadic() && i >= fnType.NumIn()-1 {
+		return fnType.In(fnType.NumIn() - 1).Elem()
+	}
+	return fnType.In(i)
+}
+
+// exprFuncOptions returns expr options of registered functions
+func exprFuncOptions(funcs []namedFunc) ([]expr.Option, bool, error) {
+	var (
+		opts     = make([]expr.Option, 0, len(funcs)+1)
+		ctxFuncs = map[string]bool{}
+	)
+	for _, f := range funcs {
+		opt, withCtx, err := exprFunc(f.name, f.fn)
+		if err != nil {
+			return nil, false, err
+		}
+		opts = append(opts, opt)
+		// The function can be redefined by the later option
+		ctxFuncs[f.name] = withCtx
+	}
+	for name, withCtx := range ctxFuncs {
+		if !withCtx {
+			delete(ctxFuncs, name)
+		}
+	}
+	if len(ctxFuncs) > 0 {
+		opts = append(opts, expr.Patch(&ctxFuncPatcher{funcs: ctxFuncs}))
+	}
+	return opts, len(ctxFuncs) > 0, nil
+}
+
+// ctxFuncPatcher passes the processing context from the data as the first argument of context functions
+type ctxFuncPatcher struct {
+	funcs map[string]bool
+}
+
+func (p *ctxFuncPatcher) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
+	}
+	if ident, ok := call.Callee.(*ast.IdentifierNode); ok && p.funcs[ident.Value] {
+		// Optional access keeps the strict environment check from failing on the hidden key
+		ctxArg := &ast.ChainNode{Node: &ast.MemberNode{
+			Node:     &ast.IdentifierNode{Value: "$env"},
+			Property: &ast.StringNode{Value: ctxDataKey},
+			Optional: true,
+		}}
+		call.Arguments = append([]ast.Node{ctxArg}, call.Arguments...)
+	}
+}
+
+// sortedFuncs returns functions of the map in the order of names
+func sortedFuncs(funcs map[string]any) []namedFunc {
+	res := make([]namedFunc, 0, len(funcs))
+	for name, fn := range funcs {
+		res = append(res, namedFunc{name: name, fn: fn})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
@@ -0,0 +1,143 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTenantKey struct{}
+
+func TestWithFunc(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"name":   "{{greet(name, 3)}}",
+		"tenant": "{{tenant('id')}}",
+		"piped":  "{{name | tenant()}}",
+		"sum":    "{{sum3(1, 2, 3)}}",
+		"items": map[string]any{
+			"$iterate": "items",
+			"$body":    "{{tenant(item)}}",
+		},
+	},
+		WithFunc("greet", func(name string, times int) string { return strings.Repeat("hi "+name+"!", times) }),
+		WithFuncs(map[string]any{
+			"tenant": func(ctx context.Context, key string) (string, error) {
+				tenant, _ := ctx.Value(testTenantKey{}).(string)
+				if tenant == "" {
+					return "", errors.New("no tenant")
+				}
+				return tenant + "/" + key, nil
+			},
+			"sum3": func(nums ...int) int {
+				res := 0
+				for _, n := range nums {
+					res += n
+				}
+				return res
+			},
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx := context.WithValue(context.Background(), testTenantKey{}, "acme")
+	res, err := tpl.Process(ctx, map[string]any{"name": "bob", "items": []any{"a", "b"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{
+			"name":   "hi bob!hi bob!hi bob!",
+			"tenant": "acme/id",
+			"piped":  "acme/bob",
+			"sum":    6,
+			"items":  []any{"acme/a", "acme/b"},
+		}, res)
+	}
+
+	_, err = tpl.Process(context.Background(), map[string]any{"name": "bob", "items": []any{}})
+	var tplErr *TemplateError
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Contains(t, err.Error(), "no tenant")
+	}
+}
+
+func TestWithFuncErrors(t *testing.T) {
+	greet := WithFunc("greet", func(name string) string { return "hi " + name })
+	tests := []struct {
+		tpl  any
+		opts []Option
+		err  error
+	}{
+		{tpl: "{{greet(1)}}", opts: []Option{greet}},
+		{tpl: "{{greet('a', 'b')}}", opts: []Option{greet}},
+		{tpl: "{{greet()}}", opts: []Option{WithFunc("greet", "hi")}, err: errInvalidFunc},
+		{tpl: "{{greet()}}", opts: []Option{WithFunc("greet", func() {})}, err: errInvalidFunc},
+		{tpl: "{{greet()}}", opts: []Option{WithFunc("greet", func() error { return nil })}, err: errInvalidFunc},
+		{tpl: "{{ctxFn('a')}}", opts: []Option{WithFunc("ctxFn", func(ctx context.Context) string { return "" })}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.tpl), func(t *testing.T) {
+			_, err := NewTemplateFor(test.tpl, test.opts...)
+			if assert.Error(t, err) && test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestWithFuncEnv(t *testing.T) {
+	tpl, err := NewTemplateFor("{{prefix(Name)}}",
+		WithExprEnv(map[string]any{"Name": ""}),
+		WithFunc("prefix", func(ctx context.Context, s string) string { return "x-" + s }))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"Name": "web"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "x-web", res)
+	}
+}
+
+func TestWithFuncDynamicArgs(t *testing.T) {
+	opts := []Option{
+		WithFunc("greet", func(name string) string { return "hi " + name }),
+		WithFunc("half", func(n int) int { return n / 2 }),
+		WithFunc("scale", func(ctx context.Context, f float64, n uint8) float64 { return f * float64(n) }),
+		WithFunc("joinAll", func(items []string) string { return strings.Join(items, ",") }),
+	}
+	data := map[string]any{"num": 65, "frac": 3.9, "whole": 4.0, "str": "abc", "big": 300, "list": []any{"a", 1}, "nested": []any{[]any{1}}}
+	tests := []struct {
+		expr   string
+		result any
+		error  string
+	}{
+		{expr: "greet(num)", result: "hi 65"},
+		{expr: "greet(str)", result: "hi abc"},
+		{expr: "greet(list)", error: "greet: argument 1 must be a string, got []interface {}"},
+		{expr: "half(whole)", result: 2},
+		{expr: "half(frac)", error: "half: argument 1 must be an integer, got float64"},
+		{expr: "half(str)", error: "half: argument 1 must be an integer, got string"},
+		{expr: "scale(frac, num)", result: 253.5},
+		{expr: "scale(frac, big)", error: "scale: argument 2 must be a non-negative integer, got int"},
+		{expr: "joinAll(list)", result: "a,1"},
+		{expr: "joinAll(nested)", error: "joinAll: argument 1 must be []string, got []interface {}"},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			tpl, err := NewTemplateFor("{{"+test.expr+"}}", opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := tpl.Process(context.Background(), data)
+			if test.error != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.error)
+				}
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, test.result, res)
+			}
+		})
+	}
+}
@@ -3,16 +3,17 @@ module github.com/demdxx/datatemplate
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/antonmedv/expr v1.15.5
 	github.com/demdxx/gocast/v2 v2.7.0
 	github.com/demdxx/xtypes v0.1.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/exp v0.0.0-20231127185646-65229373498e // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
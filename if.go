@@ -9,6 +9,7 @@ import (
 )
 
 type IfBlock struct {
+	blockSource
 	cond      *Program
 	thenBlock Block
 	elseBlock Block
@@ -43,7 +44,7 @@ func (b *IfBlock) String() string {
 func (b *IfBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
 	res, err := runExpr(ctx, b.cond, data)
 	if err != nil {
-		return nil, err
+		return nil, b.wrapError(err)
 	}
 	if gocast.Bool(res) {
 		return b.thenBlock.Emit(ctx, data)
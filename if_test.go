@@ -23,4 +23,37 @@ func TestIfBlock(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "false", res)
 	})
+
+	t.Run("elif", func(t *testing.T) {
+		_if, err := parseIfBlock(ctx, toOrderedMap(map[string]any{
+			"$if": map[string]any{"$cond": "env == 'prod'", "replicas": 3},
+			"$elif": []any{
+				map[string]any{"$cond": "env == 'stage'", "replicas": 2},
+				map[string]any{"$cond": "true", "replicas": 1},
+				map[string]any{"$cond": "env == 'test'", "replicas": 0},
+			},
+		}))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "$if: {`$expr`: `env == 'prod'`, $then: map[replicas:3], "+
+			"$else: $if: {`$expr`: `env == 'stage'`, $then: map[replicas:2], $else: map[replicas:1]}}", _if.String())
+
+		for env, replicas := range map[string]int{"prod": 3, "stage": 2, "dev": 1} {
+			res, err := _if.Emit(context.TODO(), map[string]any{"env": env})
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]any{"replicas": replicas}, res)
+		}
+	})
+
+	t.Run("elif-folding", func(t *testing.T) {
+		_if, err := parseIfBlock(ctx, toOrderedMap(map[string]any{
+			"$if":   map[string]any{"$cond": "false", "replicas": 3},
+			"$elif": []any{map[string]any{"$cond": "false", "replicas": 2}},
+			"$else": map[string]any{"replicas": 1},
+		}))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "map[replicas:1]", _if.String())
+		}
+	})
 }
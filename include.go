@@ -0,0 +1,72 @@
+package datatemplate
+
+import (
+	"context"
+	"strings"
+
+	"github.com/demdxx/xtypes"
+)
+
+// IncludeVar is the variable which is bound to the included template
+type IncludeVar struct {
+	Name  string
+	Value Block
+}
+
+// IncludeBlock emits the template included from the template set
+// with the data of the including template and bound variables
+type IncludeBlock struct {
+	blockSource
+	name string
+	vars []*IncludeVar
+	body Block
+}
+
+func NewIncludeBlock(name string, vars []*IncludeVar, body Block) *IncludeBlock {
+	return &IncludeBlock{name: name, vars: vars, body: body}
+}
+
+func (b *IncludeBlock) String() string {
+	var buf strings.Builder
+	buf.WriteString("$include: {`$name`: " + b.name)
+	if len(b.vars) > 0 {
+		buf.WriteString(", $with: {")
+		for i, v := range b.vars {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(v.Name + ": ")
+			if v.Value != nil {
+				buf.WriteString(v.Value.String())
+			}
+		}
+		buf.WriteString("}")
+	}
+	if b.body != nil {
+		buf.WriteString(", $body: " + b.body.String())
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func (b *IncludeBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	if b.body == nil {
+		return nil, nil
+	}
+	if len(b.vars) == 0 {
+		return b.body.Emit(ctx, data)
+	}
+	newData := xtypes.Map[string, any](data).Copy()
+	for _, v := range b.vars {
+		if v.Value == nil {
+			newData[v.Name] = nil
+			continue
+		}
+		val, err := v.Value.Emit(ctx, data)
+		if err != nil {
+			return nil, b.wrapError(err)
+		}
+		newData[v.Name] = plainValue(val)
+	}
+	return b.body.Emit(ctx, newData)
+}
@@ -2,92 +2,455 @@ package datatemplate
 
 import (
 	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/demdxx/gocast/v2"
 	"github.com/demdxx/xtypes"
 	"github.com/pkg/errors"
 )
 
-var errInvalidIteratator = errors.New("invalid iterator")
+var (
+	errInvalidIteratator = errors.New("invalid iterator")
+	errDuplicateKey      = errors.New("duplicate key")
+)
+
+// DuplicateKeyPolicy defines the behaviour of the iterate block
+// when the output key is already present in the result map
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeyError returns an error on the duplicate key
+	DuplicateKeyError DuplicateKeyPolicy = iota
+	// DuplicateKeyFirst keeps the first value of the key
+	DuplicateKeyFirst
+	// DuplicateKeyLast keeps the last value of the key
+	DuplicateKeyLast
+)
+
+func (p DuplicateKeyPolicy) String() string {
+	switch p {
+	case DuplicateKeyFirst:
+		return "first"
+	case DuplicateKeyLast:
+		return "last"
+	default:
+		return "error"
+	}
+}
+
+// IterateOption defines additional processing rules of the iterate block
+type IterateOption func(it *IterateBlock)
+
+// WithIterateMapOutput makes the iterate block emit the map instead of the list.
+// The key block calculates the output key of the item, if it's nil then
+// the source key is used for maps and the index for slices.
+func WithIterateMapOutput(key Block, policy DuplicateKeyPolicy) IterateOption {
+	return func(it *IterateBlock) {
+		it.asMap = true
+		it.outKey = key
+		it.duplicatePolicy = policy
+	}
+}
+
+// withIterateOrderedOutput makes the map output to be *OrderedMap in the order of iteration
+func withIterateOrderedOutput() IterateOption {
+	return func(it *IterateBlock) {
+		it.orderedOutput = true
+	}
+}
+
+// WithIterateKeyOrder sets the order of map keys iteration,
+// by default keys are iterated in the ascending order
+func WithIterateKeyOrder(less func(a, b string) bool) IterateOption {
+	return func(it *IterateBlock) {
+		it.keyLess = less
+	}
+}
+
+// WithIterateWhere sets the condition which filters iterated items
+func WithIterateWhere(cond *Program) IterateOption {
+	return func(it *IterateBlock) {
+		it.where = cond
+	}
+}
+
+// WithIterateSortBy sets the expression which result is used to sort iterated items
+func WithIterateSortBy(expr *Program, desc bool) IterateOption {
+	return func(it *IterateBlock) {
+		it.sortBy = expr
+		it.sortDesc = desc
+	}
+}
+
+// WithIterateLimit sets the maximal number of iterated items, the zero limit emits nothing
+func WithIterateLimit(limit int) IterateOption {
+	return func(it *IterateBlock) {
+		it.limit = limit
+	}
+}
+
+// WithIterateOffset sets the number of items which must be skipped
+func WithIterateOffset(offset int) IterateOption {
+	return func(it *IterateBlock) {
+		it.offset = offset
+	}
+}
+
+// WithIterateLimitExpr sets the expression which result is the maximal number of iterated items
+func WithIterateLimitExpr(expr *Program) IterateOption {
+	return func(it *IterateBlock) {
+		it.limitExpr = expr
+	}
+}
+
+// WithIterateOffsetExpr sets the expression which result is the number of items which must be skipped
+func WithIterateOffsetExpr(expr *Program) IterateOption {
+	return func(it *IterateBlock) {
+		it.offsetExpr = expr
+	}
+}
 
 type IterateBlock struct {
+	blockSource
 	expr      *Program
 	indexName string
 	keyName   string
 	valueName string
 	block     Block
+	keyLess   func(a, b string) bool
+
+	where    *Program
+	sortBy   *Program
+	sortDesc bool
+	limit    int // -1 means no limit
+	offset   int
+
+	limitExpr  *Program
+	offsetExpr *Program
+
+	asMap           bool
+	outKey          Block
+	duplicatePolicy DuplicateKeyPolicy
+	orderedOutput   bool
 }
 
-func NewIterateBlock(expr *Program, indexName, keyName, valueName string, block Block) *IterateBlock {
-	return &IterateBlock{
+func NewIterateBlock(expr *Program, indexName, keyName, valueName string, block Block, opts ...IterateOption) *IterateBlock {
+	it := &IterateBlock{
 		expr:      expr,
 		indexName: strOrDef(indexName, "index"),
 		keyName:   strOrDef(keyName, "key"),
 		valueName: strOrDef(valueName, "item"),
 		block:     block,
+		limit:     -1,
+	}
+	for _, opt := range opts {
+		opt(it)
 	}
+	return it
 }
 
-func NewIterateBlockFromExpr(ctx context.Context, expression, indexName, keyName, valueName string, block Block) (*IterateBlock, error) {
+func NewIterateBlockFromExpr(ctx context.Context, expression, indexName, keyName, valueName string, block Block, opts ...IterateOption) (*IterateBlock, error) {
 	program, err := compileExpr(ctx, expression)
 	if err != nil {
 		return nil, errors.Wrap(err, expression)
 	}
-	return NewIterateBlock(program, indexName, keyName, valueName, block), nil
+	return NewIterateBlock(program, indexName, keyName, valueName, block, opts...), nil
 }
 
 func (it *IterateBlock) String() string {
-	return "$iterate: {`$expr`: `" + it.expr.Source.Content() +
+	var buf strings.Builder
+	buf.WriteString("$iterate: {`$expr`: `" + it.expr.Source.Content() +
 		"`, $index: `" + it.indexName +
 		"`, $key: `" + it.keyName +
-		"`, $value: `" + it.valueName +
-		"`, $body: " + it.block.String() + "}"
+		"`, $value: `" + it.valueName + "`")
+	if it.where != nil {
+		buf.WriteString(", $where: `" + it.where.Source.Content() + "`")
+	}
+	if it.sortBy != nil {
+		buf.WriteString(", $sortBy: `" + it.sortBy.Source.Content() + "`")
+		if it.sortDesc {
+			buf.WriteString(" desc")
+		}
+	}
+	if it.offsetExpr != nil {
+		buf.WriteString(", $offset: `" + it.offsetExpr.Source.Content() + "`")
+	} else if it.offset > 0 {
+		buf.WriteString(", $offset: " + strconv.Itoa(it.offset))
+	}
+	if it.limitExpr != nil {
+		buf.WriteString(", $limit: `" + it.limitExpr.Source.Content() + "`")
+	} else if it.limit >= 0 {
+		buf.WriteString(", $limit: " + strconv.Itoa(it.limit))
+	}
+	if it.asMap {
+		buf.WriteString(", $as: map")
+		if it.outKey != nil {
+			buf.WriteString(", $outKey: " + it.outKey.String())
+		}
+		buf.WriteString(", $onDuplicate: " + it.duplicatePolicy.String())
+	}
+	buf.WriteString(", $body: " + it.block.String() + "}")
+	return buf.String()
 }
 
+// Emit iterates over the slice or map items and emits the body block for each of them.
+// The `$where` and `$sortBy` expressions see the source position of the item as index,
+// and the body sees the position of the item in the result list.
 func (it *IterateBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
 	otData, err := runExpr(ctx, it.expr, data)
 	if err != nil {
-		return nil, err
+		return nil, it.wrapError(err)
 	}
-	if !gocast.IsSlice(otData) && !gocast.IsMap(otData) {
-		return nil, errors.Wrap(errInvalidIteratator, "not a slice or map")
+	if _, ok := otData.(*OrderedMap); !ok && !gocast.IsSlice(otData) && !gocast.IsMap(otData) {
+		return nil, it.wrapError(errors.Wrap(errInvalidIteratator, "not a slice or map"))
 	}
 
 	// copy context data
 	nData := xtypes.Map[string, any](data).Copy()
 
-	// iterate slice object data
+	st := ctxRenderState(ctx)
+	source := it.sourceItems(otData)
+	if err := st.checkIterations(len(source)); err != nil {
+		return nil, it.wrapError(err)
+	}
+	items, err := it.prepareItems(ctx, nData, source)
+	if err != nil {
+		return nil, it.wrapError(err)
+	}
+	if err := st.enter(); err != nil {
+		return nil, it.wrapError(err)
+	}
+	defer st.leave()
+
+	if it.asMap {
+		return it.emitMap(ctx, nData, items)
+	}
+
+	res := make([]any, 0, len(items))
+	for index, item := range items {
+		if err := ctxCanceled(ctx); err != nil {
+			return nil, it.wrapError(err)
+		}
+		it.bind(nData, index, item)
+		if rData, err := it.block.Emit(ctx, nData); err != nil {
+			return nil, err
+		} else if rData != nil {
+			res = append(res, rData)
+			if err := st.addNodes(1); err != nil {
+				return nil, it.wrapError(err)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (it *IterateBlock) emitMap(ctx context.Context, data map[string]any, items []*iterateItem) (any, error) {
+	var (
+		res = NewOrderedMap()
+		// Keys of all items including ones with the nil body which is not emitted
+		seen = make(map[string]bool, len(items))
+	)
+	for index, item := range items {
+		if err := ctxCanceled(ctx); err != nil {
+			return nil, it.wrapError(err)
+		}
+		it.bind(data, index, item)
+		key, err := it.itemKey(ctx, data, index, item)
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
+			switch it.duplicatePolicy {
+			case DuplicateKeyFirst:
+				continue
+			case DuplicateKeyError:
+				return nil, it.wrapError(errors.Wrap(errDuplicateKey, key))
+			}
+		}
+		seen[key] = true
+		if rData, err := it.block.Emit(ctx, data); err != nil {
+			return nil, err
+		} else if rData != nil {
+			res.Set(key, rData)
+			if err := ctxRenderState(ctx).addNodes(1); err != nil {
+				return nil, it.wrapError(err)
+			}
+		}
+	}
+	if it.orderedOutput {
+		return res, nil
+	}
+	return res.values, nil
+}
+
+func (it *IterateBlock) itemKey(ctx context.Context, data map[string]any, index int, item *iterateItem) (string, error) {
+	if it.outKey == nil {
+		if item.key != nil {
+			return gocast.Str(item.key), nil
+		}
+		return strconv.Itoa(index), nil
+	}
+	key, err := it.outKey.Emit(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	return gocast.Str(key), nil
+}
+
+type iterateItem struct {
+	key     any
+	value   any
+	index   int
+	sortKey any
+}
+
+// sourceItems returns the list of items of the slice or map object data
+func (it *IterateBlock) sourceItems(otData any) []*iterateItem {
 	if gocast.IsSlice(otData) {
 		list := gocast.AnySlice[any](otData)
-		res := make([]any, 0, len(list))
+		items := make([]*iterateItem, 0, len(list))
 		for index, item := range list {
-			nData[it.indexName] = index
-			nData[it.valueName] = item
-			if rData, err := it.block.Emit(ctx, nData); err != nil {
+			items = append(items, &iterateItem{value: item, index: index})
+		}
+		return items
+	}
+	if om, ok := otData.(*OrderedMap); ok {
+		keys := om.Keys()
+		if it.keyLess != nil {
+			sort.SliceStable(keys, func(i, j int) bool { return it.keyLess(keys[i], keys[j]) })
+		}
+		items := make([]*iterateItem, 0, len(keys))
+		for index, key := range keys {
+			items = append(items, &iterateItem{key: key, value: om.values[key], index: index})
+		}
+		return items
+	}
+	mp := gocast.Map[string, any](otData)
+	keys := make([]string, 0, len(mp))
+	for key := range mp {
+		keys = append(keys, key)
+	}
+	// Keys are sorted before the custom ordering to keep the order of equal keys stable
+	sort.Strings(keys)
+	if it.keyLess != nil {
+		sort.SliceStable(keys, func(i, j int) bool { return it.keyLess(keys[i], keys[j]) })
+	}
+	items := make([]*iterateItem, 0, len(mp))
+	for index, key := range keys {
+		items = append(items, &iterateItem{key: key, value: mp[key], index: index})
+	}
+	return items
+}
+
+// prepareItems applies filtering, sorting and pagination rules to the items
+func (it *IterateBlock) prepareItems(ctx context.Context, data map[string]any, items []*iterateItem) ([]*iterateItem, error) {
+	offset, err := it.evalCount(ctx, data, it.offsetExpr, it.offset, "$offset")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := it.evalCount(ctx, data, it.limitExpr, it.limit, "$limit")
+	if err != nil {
+		return nil, err
+	}
+
+	if it.where != nil {
+		filtered := items[:0]
+		for _, item := range items {
+			it.bind(data, item.index, item)
+			res, err := runExpr(ctx, it.where, data)
+			if err != nil {
 				return nil, err
-			} else if rData != nil {
-				res = append(res, rData)
+			}
+			if gocast.Bool(res) {
+				filtered = append(filtered, item)
 			}
 		}
-		return res, nil
+		items = filtered
 	}
 
-	// iterate map object data
-	mp := gocast.Map[string, any](otData)
-	res := make([]any, 0, len(mp))
-	index := 0
-	for key, item := range mp {
-		nData[it.keyName] = key
-		nData[it.valueName] = item
-		nData[it.indexName] = index
-		if rData, err := it.block.Emit(ctx, nData); err != nil {
-			return nil, err
-		} else {
-			res = append(res, rData)
+	if it.sortBy != nil {
+		for _, item := range items {
+			it.bind(data, item.index, item)
+			res, err := runExpr(ctx, it.sortBy, data)
+			if err != nil {
+				return nil, err
+			}
+			item.sortKey = res
 		}
-		index++
+		sort.SliceStable(items, func(i, j int) bool {
+			if it.sortDesc {
+				return compareValues(items[j].sortKey, items[i].sortKey) < 0
+			}
+			return compareValues(items[i].sortKey, items[j].sortKey) < 0
+		})
 	}
-	return res, nil
+
+	if offset > 0 {
+		if offset >= len(items) {
+			return nil, nil
+		}
+		items = items[offset:]
+	}
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// evalCount returns the value of the `$limit` or `$offset` option, the expression result must be a non-negative integer
+func (it *IterateBlock) evalCount(ctx context.Context, data map[string]any, expr *Program, def int, name string) (int, error) {
+	if expr == nil {
+		return def, nil
+	}
+	res, err := runExpr(ctx, expr, data)
+	if err != nil {
+		return 0, err
+	}
+	num, err := gocast.TryNumber[int](res)
+	if res == nil || err != nil || num < 0 {
+		return 0, errors.Wrapf(errInvalidIteratator, "%s must be a non-negative integer, got %v", name, res)
+	}
+	return num, nil
+}
+
+func (it *IterateBlock) bind(data map[string]any, index int, item *iterateItem) {
+	if item.key != nil {
+		data[it.keyName] = item.key
+	}
+	data[it.valueName] = item.value
+	data[it.indexName] = index
+}
+
+// compareValues returns -1, 0 or 1 if a is less, equal or greater than b.
+// Numbers are compared by value, time by instant, other values by string representation.
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if isNumber(a) && isNumber(b) {
+		fa, fb := gocast.Float64(a), gocast.Float64(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(gocast.Str(a), gocast.Str(b))
 }
 
 func strOrDef(s, def string) string {
@@ -71,3 +71,169 @@ func tNewExpr(ctx context.Context, tpl string) Block {
 	bl, _ := NewExprBlockFromString(ctx, tpl)
 	return bl.(Block)
 }
+
+func TestIterateBlockOptions(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"backends": []any{
+			map[string]any{"host": "a", "weight": 10, "active": true},
+			map[string]any{"host": "b", "weight": 30, "active": false},
+			map[string]any{"host": "c", "weight": 20, "active": true},
+			map[string]any{"host": "d", "weight": 40, "active": true},
+			map[string]any{"host": "e", "weight": 5, "active": true},
+		},
+		"count": 2,
+	}
+	tests := []struct {
+		opts []IterateOption
+		res  []any
+	}{
+		{
+			opts: []IterateOption{WithIterateWhere(tCompile(ctx, "item.active"))},
+			res:  []any{"0:a", "1:c", "2:d", "3:e"},
+		},
+		{
+			opts: []IterateOption{WithIterateSortBy(tCompile(ctx, "item.weight"), false)},
+			res:  []any{"0:e", "1:a", "2:c", "3:b", "4:d"},
+		},
+		{
+			opts: []IterateOption{
+				WithIterateWhere(tCompile(ctx, "item.active")),
+				WithIterateSortBy(tCompile(ctx, "item.weight"), true),
+				WithIterateLimit(3),
+			},
+			res: []any{"0:d", "1:c", "2:a"},
+		},
+		{
+			opts: []IterateOption{WithIterateOffset(1), WithIterateLimit(2)},
+			res:  []any{"0:b", "1:c"},
+		},
+		{
+			opts: []IterateOption{WithIterateOffset(10)},
+			res:  []any{},
+		},
+		{
+			opts: []IterateOption{WithIterateLimit(0)},
+			res:  []any{},
+		},
+		{
+			opts: []IterateOption{WithIterateOffsetExpr(tCompile(ctx, "1")), WithIterateLimitExpr(tCompile(ctx, "count"))},
+			res:  []any{"0:b", "1:c"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(gocast.Str(i), func(t *testing.T) {
+			iterate, err := NewIterateBlockFromExpr(ctx, "backends", "", "", "",
+				tNewExpr(ctx, "{{index}}:{{item.host}}"), test.opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := iterate.Emit(ctx, data)
+			assert.NoError(t, err)
+			assert.Equal(t, test.res, res)
+		})
+	}
+}
+
+func TestIterateBlockLimitExpr(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"$iterate": "items",
+		"$limit":   "{{count}}",
+		"$body":    "{{item}}",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for count, res := range map[int][]any{0: {}, 2: {1, 2}, 5: {1, 2, 3}} {
+		out, err := tpl.Process(context.Background(), map[string]any{"items": []any{1, 2, 3}, "count": count})
+		assert.NoError(t, err)
+		assert.Equal(t, res, out)
+	}
+	_, err = tpl.Process(context.Background(), map[string]any{"items": []any{1, 2, 3}, "count": -1})
+	assert.ErrorIs(t, err, errInvalidIteratator)
+}
+
+func tCompile(ctx context.Context, expression string) *Program {
+	program, err := compileExpr(ctx, expression)
+	if err != nil {
+		panic(err)
+	}
+	return program
+}
+
+func TestIterateBlockMapOutput(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"services": []any{
+			map[string]any{"name": "web", "image": "nginx:1"},
+			map[string]any{"name": "db", "image": "postgres"},
+			map[string]any{"name": "web", "image": "nginx:2"},
+		},
+	}
+	tests := []struct {
+		policy DuplicateKeyPolicy
+		res    any
+		err    error
+	}{
+		{policy: DuplicateKeyError, err: errDuplicateKey},
+		{policy: DuplicateKeyFirst, res: map[string]any{"web": "nginx:1", "db": "postgres"}},
+		{policy: DuplicateKeyLast, res: map[string]any{"web": "nginx:2", "db": "postgres"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			iterate, err := NewIterateBlockFromExpr(ctx, "services", "", "", "",
+				tNewExpr(ctx, "{{item.image}}"),
+				WithIterateMapOutput(tNewExpr(ctx, "{{item.name}}"), test.policy))
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := iterate.Emit(ctx, data)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, test.res, res)
+			}
+		})
+	}
+
+	t.Run("nil-body", func(t *testing.T) {
+		// The key of the item with the nil body is a duplicate as well
+		iterate, err := NewIterateBlockFromExpr(ctx, "services", "", "", "",
+			tNewExpr(ctx, "{{item.image}}"),
+			WithIterateMapOutput(tNewExpr(ctx, "{{item.name}}"), DuplicateKeyError))
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = iterate.Emit(ctx, map[string]any{"services": []any{
+			map[string]any{"name": "web"},
+			map[string]any{"name": "web", "image": "nginx:2"},
+		}})
+		assert.ErrorIs(t, err, errDuplicateKey)
+	})
+}
+
+func TestIterateBlockKeyOrder(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{"ports": map[string]any{"http": 80, "https": 443, "admin": 8080, "db": 5432}}
+
+	iterate, err := NewIterateBlockFromExpr(ctx, "ports", "", "", "", tNewExpr(ctx, "{{index}}:{{key}}"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		res, err := iterate.Emit(ctx, data)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"0:admin", "1:db", "2:http", "3:https"}, res)
+	}
+
+	iterate, err = NewIterateBlockFromExpr(ctx, "ports", "", "", "", tNewExpr(ctx, "{{index}}:{{key}}"),
+		WithIterateKeyOrder(func(a, b string) bool { return a > b }))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := iterate.Emit(ctx, data)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{"0:https", "1:http", "2:db", "3:admin"}, res)
+}
@@ -0,0 +1,115 @@
+package datatemplate
+
+import (
+	"context"
+	"strconv"
+)
+
+// DefaultMaxCallDepth is the maximal depth of recursive macro calls if Limits.MaxCallDepth is not set
+const DefaultMaxCallDepth = 64
+
+// Limits of the template processing, the zero value of the limit means no limit
+// except MaxCallDepth which falls back to DefaultMaxCallDepth
+type Limits struct {
+	// MaxNodes is the maximal number of values emitted into the result maps and lists
+	MaxNodes int
+
+	// MaxIterations is the maximal number of source items of the single `$iterate` block
+	MaxIterations int
+
+	// MaxDepth is the maximal nesting depth of the result maps and lists
+	MaxDepth int
+
+	// MaxStringLength is the maximal length of the string produced by the expression or the string template like "{{name}}-{{id}}".
+	// It's checked after the expression is evaluated, so it keeps long strings out of the result
+	// but doesn't bound the memory used by expressions like `repeat("x", 1e9)`,
+	// deny such functions with WithDeniedFuncs for untrusted templates.
+	MaxStringLength int
+
+	// MaxCallDepth is the maximal depth of recursive macro calls
+	MaxCallDepth int
+}
+
+func (l Limits) empty() bool {
+	return l.MaxNodes <= 0 && l.MaxIterations <= 0 && l.MaxDepth <= 0 && l.MaxStringLength <= 0 && l.MaxCallDepth <= 0
+}
+
+// LimitError is returned if the template processing exceeds one of the Limits,
+// it's wrapped by *TemplateError with the path of the template node
+type LimitError struct {
+	// Limit name: nodes, iterations, depth or string length
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return "limit exceeded: max " + e.Limit + " " + strconv.Itoa(e.Max)
+}
+
+// renderState tracks the budget of the single template processing
+type renderState struct {
+	limits Limits
+	nodes  int
+	depth  int
+}
+
+func ctxWithRenderState(ctx context.Context, limits Limits) context.Context {
+	return context.WithValue(ctx, ctxRenderStateKey, &renderState{limits: limits})
+}
+
+// ctxRenderState returns the render state of the processing or nil if there are no limits
+func ctxRenderState(ctx context.Context) *renderState {
+	st, _ := ctx.Value(ctxRenderStateKey).(*renderState)
+	return st
+}
+
+// addNodes counts emitted values of the result
+func (st *renderState) addNodes(n int) error {
+	if st == nil || st.limits.MaxNodes <= 0 {
+		return nil
+	}
+	if st.nodes += n; st.nodes > st.limits.MaxNodes {
+		return &LimitError{Limit: "nodes", Max: st.limits.MaxNodes}
+	}
+	return nil
+}
+
+// enter increments the nesting depth of the result, every successful call must be paired with leave
+func (st *renderState) enter() error {
+	if st == nil {
+		return nil
+	}
+	if st.limits.MaxDepth > 0 && st.depth >= st.limits.MaxDepth {
+		return &LimitError{Limit: "depth", Max: st.limits.MaxDepth}
+	}
+	st.depth++
+	return nil
+}
+
+func (st *renderState) leave() {
+	if st != nil {
+		st.depth--
+	}
+}
+
+func (st *renderState) checkIterations(n int) error {
+	if st != nil && st.limits.MaxIterations > 0 && n > st.limits.MaxIterations {
+		return &LimitError{Limit: "iterations", Max: st.limits.MaxIterations}
+	}
+	return nil
+}
+
+func (st *renderState) checkStringLength(n int) error {
+	if st != nil && st.limits.MaxStringLength > 0 && n > st.limits.MaxStringLength {
+		return &LimitError{Limit: "string length", Max: st.limits.MaxStringLength}
+	}
+	return nil
+}
+
+// maxCallDepth returns the macro call depth limit of the processing
+func (st *renderState) maxCallDepth() int {
+	if st == nil || st.limits.MaxCallDepth <= 0 {
+		return DefaultMaxCallDepth
+	}
+	return st.limits.MaxCallDepth
+}
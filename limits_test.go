@@ -0,0 +1,102 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateLimits(t *testing.T) {
+	tpl := map[string]any{
+		"name": "{{name}}-{{suffix}}",
+		"items": map[string]any{
+			"$iterate": "items",
+			"$body":    map[string]any{"id": "{{item}}", "tags": []any{"{{item}}", "x"}},
+		},
+	}
+	data := map[string]any{"name": "web", "suffix": "api", "items": []any{1, 2, 3}}
+	tests := []struct {
+		name   string
+		limits Limits
+		limit  string
+		path   string
+	}{
+		{name: "iterations", limits: Limits{MaxIterations: 2}, limit: "iterations", path: "/items/$iterate"},
+		{name: "nodes", limits: Limits{MaxNodes: 8}, limit: "nodes", path: "/items/$body"},
+		{name: "depth", limits: Limits{MaxDepth: 3}, limit: "depth", path: "/items/$body/tags"},
+		{name: "string", limits: Limits{MaxStringLength: 5}, limit: "string length", path: "/name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tpl, err := NewTemplateFor(tpl, WithLimits(test.limits))
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = tpl.Process(context.Background(), data)
+			var (
+				limitErr *LimitError
+				tplErr   *TemplateError
+			)
+			if assert.True(t, errors.As(err, &limitErr), err) && assert.True(t, errors.As(err, &tplErr)) {
+				assert.Equal(t, test.limit, limitErr.Limit)
+				assert.Equal(t, test.path, tplErr.Path)
+			}
+		})
+	}
+
+	t.Run("fit", func(t *testing.T) {
+		tpl, err := NewTemplateFor(tpl, WithLimits(Limits{MaxNodes: 17, MaxIterations: 3, MaxDepth: 4, MaxStringLength: 7}))
+		if !assert.NoError(t, err) {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			res, err := tpl.Process(context.Background(), data)
+			if assert.NoError(t, err) {
+				assert.Len(t, res.(map[string]any)["items"], 3)
+			}
+		}
+	})
+}
+
+func TestTemplateLimitsStringExpr(t *testing.T) {
+	tests := map[string]string{
+		"{{s= name + suffix}}": "weba",
+		"{{name + suffix}}":    "weba",
+		"{{name}}{{suffix}}!":  "weba!",
+	}
+	for expression, res := range tests {
+		tpl, err := NewTemplateFor(map[string]any{"name": expression}, WithLimits(Limits{MaxStringLength: 5}))
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = tpl.Process(context.Background(), map[string]any{"name": "web", "suffix": "api"})
+		var limitErr *LimitError
+		if assert.True(t, errors.As(err, &limitErr), expression) {
+			assert.Equal(t, "string length", limitErr.Limit)
+		}
+		out, err := tpl.Process(context.Background(), map[string]any{"name": "web", "suffix": "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{"name": res}, out)
+	}
+}
+
+func TestTemplateLimitsRecursion(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"$define": map[string]any{
+			"name":   "tree",
+			"params": []any{"level"},
+			"body":   map[string]any{"level": "{{level}}", "child": map[string]any{"$call": "tree", "$args": []any{"{{level + 1}}"}}},
+		},
+		"tree": map[string]any{"$call": "tree", "$args": []any{0}},
+	}, WithLimits(Limits{MaxDepth: 10}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tpl.Process(context.Background(), nil)
+	var limitErr *LimitError
+	if assert.True(t, errors.As(err, &limitErr), err) {
+		assert.Equal(t, "limit exceeded: max depth 10", limitErr.Error())
+	}
+}
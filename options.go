@@ -1,9 +1,75 @@
 package datatemplate
 
-import "github.com/antonmedv/expr"
+import (
+	"context"
+	"time"
+
+	"github.com/antonmedv/expr"
+)
+
+type ctxKey int
+
+const (
+	ctxOptionsKey ctxKey = iota
+	ctxPathKey
+	ctxSourceMapKey
+	ctxParseErrorsKey
+	ctxIncludeStackKey
+	ctxMacrosKey
+	ctxCallDepthKey
+	ctxRenderStateKey
+	ctxSchemaKey
+)
 
 type options struct {
 	exprOpts []expr.Option
+	exprEnv  any
+	funcs    []namedFunc
+	keyLess  func(a, b string) bool
+	source   *sourceMap
+	policy   exprPolicy
+	schema   *Schema
+
+	allErrors  bool
+	lax        bool
+	noStdFuncs bool
+	clock      func() time.Time
+	stdOpts    []expr.Option
+
+	timeout time.Duration
+	limits  Limits
+
+	customDirectives []Directive
+	directives       []Directive
+
+	include *includeState
+}
+
+func ctxWithOptions(ctx context.Context, opt *options) context.Context {
+	return context.WithValue(ctx, ctxOptionsKey, opt)
+}
+
+// ctxGetOptions returns template options from the parse context
+func ctxGetOptions(ctx context.Context) *options {
+	if opt, ok := ctx.Value(ctxOptionsKey).(*options); ok {
+		return opt
+	}
+	return &options{}
+}
+
+// stdExprOptions returns expr options of the standard function library bound to the template clock
+func (o *options) stdExprOptions() []expr.Option {
+	if o.stdOpts == nil {
+		switch {
+		case !o.noStdFuncs:
+			o.stdOpts = append(append(o.stdOpts, stdFuncOptions...), timeFuncOptions(o.clock)...)
+		case o.clock != nil:
+			o.stdOpts = nowFuncOptions(o.clock)
+		default:
+			o.stdOpts = []expr.Option{}
+		}
+	}
+	return o.stdOpts
 }
 
 type Option func(o *options)
@@ -15,7 +81,141 @@ func WithExprOptions(opts ...expr.Option) Option {
 	}
 }
 
-// WithExprEnv sets environment for expressions
+// WithExprEnv sets environment for expressions,
+// with WithSchema names of the environment are available along with the data of the schema
 func WithExprEnv(env any) Option {
-	return WithExprOptions(expr.Env(env))
+	return func(o *options) {
+		o.exprOpts = append(o.exprOpts, expr.Env(env))
+		o.exprEnv = env
+	}
+}
+
+// WithFunc registers the Go function callable from expressions by the name.
+// The function returns one value or the value and error, arguments are type-checked at the parse time.
+// If the first argument of the function is context.Context then it receives the context passed to Process.
+func WithFunc(name string, fn any) Option {
+	return func(o *options) {
+		o.funcs = append(o.funcs, namedFunc{name: name, fn: fn})
+	}
+}
+
+// WithFuncs registers Go functions by names like WithFunc
+func WithFuncs(funcs map[string]any) Option {
+	return func(o *options) {
+		o.funcs = append(o.funcs, sortedFuncs(funcs)...)
+	}
+}
+
+// WithStdFuncs enables or disables the standard function library of expressions (enabled by default),
+// like parseURI, snakeCase, regexReplace, sha256, default, formatNumber or formatTime.
+// The builtins of expr like trim, upper or toJSON are not the part of the library and stay available,
+// restrict them with WithAllowedFuncs or WithDeniedFuncs.
+func WithStdFuncs(enabled bool) Option {
+	return func(o *options) {
+		o.noStdFuncs = !enabled
+	}
+}
+
+// WithClock sets the clock of the `now()` function, so the current time can be pinned in tests
+func WithClock(clock func() time.Time) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithAllowedFuncs restricts functions and methods callable from expressions to the names,
+// calls of any other function, including expr builtins like `len` or `filter`, are parse errors
+func WithAllowedFuncs(names ...string) Option {
+	return func(o *options) {
+		o.policy.allowedFuncs = stringSet(names, o.policy.allowedFuncs)
+	}
+}
+
+// WithDeniedFuncs forbids calls of functions and methods with the names in expressions
+func WithDeniedFuncs(names ...string) Option {
+	return func(o *options) {
+		o.policy.deniedFuncs = stringSet(names, o.policy.deniedFuncs)
+	}
+}
+
+// WithAllowedMembers restricts the access to fields and methods of Go structs
+// known at the compile time (like the struct passed to WithExprEnv) to the names.
+// Methods of the environment are members too, so they have to be allowed by both lists.
+// The check is done only at the compile time: members of dynamic values like structs
+// passed in the data map are not checked, so don't pass structs with secrets in the data.
+func WithAllowedMembers(names ...string) Option {
+	return func(o *options) {
+		o.policy.allowedMembers = stringSet(names, o.policy.allowedMembers)
+	}
+}
+
+// WithSchema type-checks every expression of the template against the schema of the input data,
+// including loop variables typed by the items of the iterated value
+func WithSchema(schema *Schema) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// WithMapKeyOrder sets the order of map keys iteration in `$iterate` blocks,
+// by default keys are iterated in the ascending order
+func WithMapKeyOrder(less func(a, b string) bool) Option {
+	return func(o *options) {
+		o.keyLess = less
+	}
+}
+
+// WithAllErrors makes the parser to walk the whole template and return all errors
+// as *TemplateErrors instead of failing on the first one
+func WithAllErrors() Option {
+	return func(o *options) {
+		o.allErrors = true
+	}
+}
+
+// WithStrict enables or disables the strict mode of the parser (enabled by default).
+// In the strict mode any unknown or misplaced `$` key is the parse error,
+// otherwise such keys are processed as regular data keys.
+func WithStrict(strict bool) Option {
+	return func(o *options) {
+		o.lax = !strict
+	}
+}
+
+// WithDirective registers custom directives of the template,
+// the directive with the name of the builtin one replaces it
+func WithDirective(directives ...Directive) Option {
+	return func(o *options) {
+		o.customDirectives = append(o.customDirectives, directives...)
+	}
+}
+
+// WithTimeout limits the duration of the template processing,
+// the processing is stopped with *CanceledError when the timeout is exceeded
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithLimits sets the budget of every template processing,
+// the processing is stopped with *LimitError when any of the limits is exceeded
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// withIncludeState sets the template set which resolves `$include` directives
+func withIncludeState(st *includeState) Option {
+	return func(o *options) {
+		o.include = st
+	}
+}
+
+// withSourceMap sets the source file name and node positions for errors
+func withSourceMap(src *sourceMap) Option {
+	return func(o *options) {
+		o.source = src
+	}
 }
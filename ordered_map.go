@@ -0,0 +1,302 @@
+package datatemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/demdxx/gocast/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// OrderedMap represents the map which keeps the order of keys.
+// It's used to preserve the key order of the template source through the parse and emit,
+// so the rendered configs read like their templates.
+type OrderedMap struct {
+	keys   []string
+	values map[string]any
+
+	// sorted is true if the map was built from the unordered map
+	// and keys are just sorted to be deterministic
+	sorted bool
+}
+
+// NewOrderedMap creates new empty ordered map
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{values: map[string]any{}}
+}
+
+// toOrderedMap converts any map or struct to the ordered map, keys of the unordered map are sorted
+func toOrderedMap(data any) *OrderedMap {
+	switch m := data.(type) {
+	case nil:
+		return nil
+	case *OrderedMap:
+		return m
+	case OrderedMap:
+		return &m
+	}
+	mp := gocast.Map[string, any](data)
+	keys := make([]string, 0, len(mp))
+	for key := range mp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &OrderedMap{keys: keys, values: mp, sorted: true}
+}
+
+// plainValue converts emitted *OrderedMap values to regular maps recursively,
+// so the value bound to the scope variable is accessible by expressions like `cfg.name`
+func plainValue(data any) any {
+	switch v := data.(type) {
+	case *OrderedMap:
+		res := make(map[string]any, len(v.values))
+		for key, val := range v.values {
+			res[key] = plainValue(val)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, item := range v {
+			res[i] = plainValue(item)
+		}
+		return res
+	}
+	return data
+}
+
+// isMapData returns true if the data can be converted to the ordered map
+func isMapData(data any) bool {
+	switch data.(type) {
+	case *OrderedMap, OrderedMap:
+		return true
+	}
+	return gocast.IsMap(data) || gocast.IsStruct(data)
+}
+
+// Len returns the number of keys in the map
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the list of keys in the order of insertion
+func (m *OrderedMap) Keys() []string {
+	return append([]string(nil), m.keys...)
+}
+
+// Get returns the value of the key or nil
+func (m *OrderedMap) Get(key string) any {
+	return m.values[key]
+}
+
+// Lookup returns the value of the key and true if the key is present
+func (m *OrderedMap) Lookup(key string) (any, bool) {
+	val, ok := m.values[key]
+	return val, ok
+}
+
+// Set the value of the key, new keys are appended to the end
+func (m *OrderedMap) Set(key string, val any) *OrderedMap {
+	if m.values == nil {
+		m.values = map[string]any{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = val
+	return m
+}
+
+// Delete keys from the map
+func (m *OrderedMap) Delete(keys ...string) *OrderedMap {
+	for _, key := range keys {
+		if _, ok := m.values[key]; !ok {
+			continue
+		}
+		delete(m.values, key)
+		for i, k := range m.keys {
+			if k == key {
+				m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+				break
+			}
+		}
+	}
+	return m
+}
+
+// Copy returns the shallow copy of the map
+func (m *OrderedMap) Copy() *OrderedMap {
+	values := make(map[string]any, len(m.values))
+	for key, val := range m.values {
+		values[key] = val
+	}
+	return &OrderedMap{keys: m.Keys(), values: values, sorted: m.sorted}
+}
+
+// Map returns the shallow copy of the map as a regular map
+func (m *OrderedMap) Map() map[string]any {
+	values := make(map[string]any, len(m.values))
+	for key, val := range m.values {
+		values[key] = val
+	}
+	return values
+}
+
+func (m *OrderedMap) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(key)
+		buf.WriteString(": ")
+		if sp, _ := m.values[key].(fmt.Stringer); sp != nil {
+			buf.WriteString(sp.String())
+		} else {
+			buf.WriteString(gocast.Str(m.values[key]))
+		}
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyData, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		valData, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyData)
+		buf.WriteByte(':')
+		buf.Write(valData)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (m *OrderedMap) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	val, err := decodeJSON(dec)
+	if err != nil {
+		return err
+	}
+	om, ok := val.(*OrderedMap)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal %T into the ordered map", val)
+	}
+	*m = *om
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler interface
+func (m *OrderedMap) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range m.keys {
+		var keyNode, valNode yaml.Node
+		if err := keyNode.Encode(key); err != nil {
+			return nil, err
+		}
+		if err := valNode.Encode(m.values[key]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &keyNode, &valNode)
+	}
+	return node, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface
+func (m *OrderedMap) UnmarshalYAML(node *yaml.Node) error {
+	val, err := fromYAMLNode(node)
+	if err != nil {
+		return err
+	}
+	om, ok := val.(*OrderedMap)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal %s into the ordered map", node.Tag)
+	}
+	*m = *om
+	return nil
+}
+
+// fromYAMLNode converts YAML node into the data with ordered maps
+func fromYAMLNode(node *yaml.Node) (any, error) {
+	return fromYAMLNodeAt(node, "", nil)
+}
+
+// fromYAMLNodeAt converts YAML node of the template path
+// and records positions of all nested nodes into the source map if it's defined
+func fromYAMLNodeAt(node *yaml.Node, path string, src *sourceMap) (any, error) {
+	if src != nil && node.Line > 0 {
+		src.set(path, sourcePosition{line: node.Line, column: node.Column})
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return fromYAMLNodeAt(node.Content[0], path, src)
+	case yaml.AliasNode:
+		// Nodes of the alias are positioned at the anchor, so the alias position is used for all of them
+		return fromYAMLNodeAt(node.Alias, path, nil)
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(node.Content))
+		for i, item := range node.Content {
+			val, err := fromYAMLNodeAt(item, joinPath(path, i), src)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		m := NewOrderedMap()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valNode := node.Content[i], node.Content[i+1]
+			val, err := fromYAMLNodeAt(valNode, joinPath(path, keyNode.Value), src)
+			if err != nil {
+				return nil, err
+			}
+			// Merge keys `<<: *alias` add fields which are not defined explicitly
+			if keyNode.Tag == "!!merge" {
+				mergeYAMLMap(m, val)
+				continue
+			}
+			m.Set(keyNode.Value, val)
+		}
+		return m, nil
+	}
+	var val any
+	if err := node.Decode(&val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func mergeYAMLMap(m *OrderedMap, val any) {
+	switch v := val.(type) {
+	case *OrderedMap:
+		for _, key := range v.keys {
+			if _, ok := m.values[key]; !ok {
+				m.Set(key, v.values[key])
+			}
+		}
+	case []any:
+		for _, item := range v {
+			mergeYAMLMap(m, item)
+		}
+	}
+}
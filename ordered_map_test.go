@@ -0,0 +1,102 @@
+package datatemplate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOrderedMap(t *testing.T) {
+	m := NewOrderedMap().Set("b", 1).Set("a", 2).Set("c", 3)
+	m.Set("b", 4).Delete("a")
+	assert.Equal(t, []string{"b", "c"}, m.Keys())
+	assert.Equal(t, map[string]any{"b": 4, "c": 3}, m.Map())
+	assert.Equal(t, "{b: 4, c: 3}", m.String())
+
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"b":4,"c":3}`, string(data))
+
+	var dm OrderedMap
+	assert.NoError(t, json.Unmarshal([]byte(`{"z": 1, "y": {"x": [1, "2"], "a": null}}`), &dm))
+	assert.Equal(t, []string{"z", "y"}, dm.Keys())
+	assert.Equal(t, []string{"x", "a"}, dm.Get("y").(*OrderedMap).Keys())
+}
+
+func TestOrderedMapYAML(t *testing.T) {
+	src := `
+zone: eu
+base: &base
+  replicas: 1
+  image: nginx
+service:
+  <<: *base
+  name: web
+  replicas: 2
+`
+	var m OrderedMap
+	if !assert.NoError(t, yaml.Unmarshal([]byte(src), &m)) {
+		return
+	}
+	assert.Equal(t, []string{"zone", "base", "service"}, m.Keys())
+	service := m.Get("service").(*OrderedMap)
+	assert.Equal(t, []string{"replicas", "image", "name"}, service.Keys())
+	assert.Equal(t, 2, service.Get("replicas"))
+
+	data, err := yaml.Marshal(&m)
+	assert.NoError(t, err)
+	assert.Equal(t, "zone: eu\nbase:\n    replicas: 1\n    image: nginx\nservice:\n    replicas: 2\n    image: nginx\n    name: web\n", string(data))
+}
+
+func TestTemplateOrderedOutput(t *testing.T) {
+	src := `
+name: "{{name}}"
+zone: eu
+ports:
+  $iterate: ports
+  $outKey: "{{item.name}}"
+  port: "{{item.port}}"
+  protocol: tcp
+env:
+  - $if: "debug"
+    level: debug
+    format: text
+`
+	var node yaml.Node
+	if !assert.NoError(t, yaml.Unmarshal([]byte(src), &node)) {
+		return
+	}
+	tpl, err := NewTemplateFor(&node)
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{
+		"name":  "web",
+		"debug": true,
+		"ports": []any{
+			map[string]any{"name": "https", "port": 443},
+			map[string]any{"name": "http", "port": 80},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, err := yaml.Marshal(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: web
+zone: eu
+ports:
+    https:
+        port: 443
+        protocol: tcp
+    http:
+        port: 80
+        protocol: tcp
+env:
+    - level: debug
+      format: text
+`, string(data))
+}
@@ -0,0 +1,485 @@
+package datatemplate
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/conf"
+	"github.com/antonmedv/expr/file"
+	"github.com/pkg/errors"
+)
+
+var (
+	errInvalidSchema = errors.New("invalid schema")
+
+	anyType  = reflect.TypeOf((*any)(nil)).Elem()
+	intType  = reflect.TypeOf(0)
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// Schema describes the shape of the template input data.
+// Expressions of the template are type-checked against the schema at the parse time,
+// so the typo like `{{persn.name}}` is the parse error instead of nil at the processing.
+//
+// Objects of the schema are checked as structs, so any undeclared field is the error,
+// maps and values of unknown type (nil in the example or no type in the JSON Schema) accept any access.
+type Schema struct {
+	// typ is the struct type of the root object, fields are named by `expr` tags
+	typ reflect.Type
+}
+
+// SchemaOf creates the schema from the Go type of the value (struct, pointer to struct or reflect.Type).
+// Fields are named by `expr` or `json` tags or by the field name as keys of the data.
+func SchemaOf(v any) (*Schema, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return nil, errors.Wrap(errInvalidSchema, "nil type")
+	}
+	return newSchema(goSchemaType(t, map[reflect.Type]bool{}))
+}
+
+// SchemaFromExample creates the schema from the example of the input data,
+// like the map decoded from JSON or YAML document
+func SchemaFromExample(doc any) (*Schema, error) {
+	return newSchema(exampleSchemaType(doc))
+}
+
+// ParseJSONSchema creates the schema from JSON Schema document.
+// Supported keywords are `type`, `properties`, `items` and `additionalProperties`.
+func ParseJSONSchema(data []byte) (*Schema, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(errInvalidSchema, err.Error())
+	}
+	return newSchema(jsonSchemaType(doc))
+}
+
+func newSchema(t reflect.Type) (*Schema, error) {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, errors.Wrap(errInvalidSchema, "root must be an object, got "+t.String())
+	}
+	return &Schema{typ: t}, nil
+}
+
+// rootScope returns the scope of the root object fields
+func (s *Schema) rootScope() *schemaScope {
+	scope := &schemaScope{types: make(map[string]reflect.Type, s.typ.NumField())}
+	for i := 0; i < s.typ.NumField(); i++ {
+		field := s.typ.Field(i)
+		scope.names = append(scope.names, field.Tag.Get("expr"))
+		scope.types[field.Tag.Get("expr")] = field.Type
+	}
+	return scope
+}
+
+type schemaField struct {
+	name string
+	typ  reflect.Type
+}
+
+// structOf creates the struct type with fields available in expressions by their names
+func structOf(fields []schemaField) reflect.Type {
+	structFields := make([]reflect.StructField, 0, len(fields))
+	for i, field := range fields {
+		if field.name == "$env" {
+			continue
+		}
+		structFields = append(structFields, reflect.StructField{
+			Name: "F" + strconv.Itoa(i),
+			Type: field.typ,
+			Tag:  reflect.StructTag(`expr:` + strconv.Quote(field.name)),
+		})
+	}
+	return reflect.StructOf(structFields)
+}
+
+// goSchemaType converts Go type into the schema type, structs are converted to the structs with data keys.
+// Recursive types are replaced by any on the second visit.
+func goSchemaType(t reflect.Type, visited map[reflect.Type]bool) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == timeType {
+			return t
+		}
+		if visited[t] {
+			return anyType
+		}
+		visited[t] = true
+		defer delete(visited, t)
+		return structOf(goSchemaFields(t, visited))
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return t
+		}
+		return reflect.SliceOf(goSchemaType(t.Elem(), visited))
+	case reflect.Map:
+		return reflect.MapOf(t.Key(), goSchemaType(t.Elem(), visited))
+	}
+	return t
+}
+
+func goSchemaFields(t reflect.Type, visited map[reflect.Type]bool) []schemaField {
+	var fields []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := goFieldName(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, goSchemaFields(field.Type, visited)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, schemaField{name: name, typ: goSchemaType(field.Type, visited)})
+	}
+	return fields
+}
+
+// goFieldName returns the name of the field from `expr` or `json` tags
+func goFieldName(field reflect.StructField) string {
+	if name := field.Tag.Get("expr"); name != "" {
+		return name
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// exampleSchemaType returns the type of the example value,
+// the type of the list items is the type of the first item if all items have the same type
+func exampleSchemaType(v any) reflect.Type {
+	switch val := v.(type) {
+	case nil:
+		return anyType
+	case *OrderedMap:
+		fields := make([]schemaField, 0, val.Len())
+		for _, key := range val.Keys() {
+			fields = append(fields, schemaField{name: key, typ: exampleSchemaType(val.Get(key))})
+		}
+		return structOf(fields)
+	case []any:
+		var itemType reflect.Type
+		for _, item := range val {
+			t := exampleSchemaType(item)
+			if itemType != nil && itemType != t {
+				return reflect.TypeOf([]any{})
+			}
+			itemType = t
+		}
+		if itemType == nil {
+			itemType = anyType
+		}
+		return reflect.SliceOf(itemType)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		fields := make([]schemaField, 0, len(keys))
+		for _, key := range keys {
+			fields = append(fields, schemaField{name: key, typ: exampleSchemaType(rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).Interface())})
+		}
+		return structOf(fields)
+	}
+	return goSchemaType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// jsonSchemaType converts JSON Schema into the schema type
+func jsonSchemaType(schema map[string]any) reflect.Type {
+	properties, _ := schema["properties"].(map[string]any)
+	switch jsonSchemaTypeName(schema["type"], properties != nil) {
+	case "object":
+		if properties == nil {
+			if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				return reflect.MapOf(reflect.TypeOf(""), jsonSchemaType(additional))
+			}
+			return reflect.TypeOf(map[string]any{})
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make([]schemaField, 0, len(names))
+		for _, name := range names {
+			prop, _ := properties[name].(map[string]any)
+			fields = append(fields, schemaField{name: name, typ: jsonSchemaType(prop)})
+		}
+		return structOf(fields)
+	case "array":
+		if items, ok := schema["items"].(map[string]any); ok {
+			return reflect.SliceOf(jsonSchemaType(items))
+		}
+		return reflect.TypeOf([]any{})
+	case "string":
+		return reflect.TypeOf("")
+	case "integer":
+		return intType
+	case "number":
+		return reflect.TypeOf(float64(0))
+	case "boolean":
+		return reflect.TypeOf(false)
+	}
+	return anyType
+}
+
+// jsonSchemaTypeName returns the type name of the schema, nullable types like ["string", "null"] are the base type
+func jsonSchemaTypeName(typ any, hasProperties bool) string {
+	switch t := typ.(type) {
+	case string:
+		return t
+	case []any:
+		var name string
+		for _, item := range t {
+			if s, _ := item.(string); s != "null" {
+				if name != "" {
+					return ""
+				}
+				name = s
+			}
+		}
+		return name
+	}
+	if hasProperties {
+		return "object"
+	}
+	return ""
+}
+
+// schemaScope is the set of typed variables visible to expressions of the template node
+type schemaScope struct {
+	names []string
+	types map[string]reflect.Type
+	env   any
+}
+
+type schemaVar struct {
+	name string
+	typ  reflect.Type
+}
+
+// with returns the new scope with variables, variables override the ones with the same names
+func (s *schemaScope) with(vars ...schemaVar) *schemaScope {
+	scope := &schemaScope{
+		names: s.names[:len(s.names):len(s.names)],
+		types: make(map[string]reflect.Type, len(s.types)+len(vars)),
+	}
+	for name, t := range s.types {
+		scope.types[name] = t
+	}
+	for _, v := range vars {
+		if v.name == "" {
+			continue
+		}
+		if _, ok := scope.types[v.name]; !ok {
+			scope.names = append(scope.names, v.name)
+		}
+		if v.typ == nil {
+			v.typ = anyType
+		}
+		scope.types[v.name] = v.typ
+	}
+	return scope
+}
+
+// key returns the string which identifies variables and their types of the scope
+func (s *schemaScope) key() string {
+	if s == nil {
+		return ""
+	}
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(s.types[name].String())
+		buf.WriteByte(';')
+	}
+	return buf.String()
+}
+
+// envValue returns the value of the struct with scope variables for the type checking
+func (s *schemaScope) envValue() any {
+	if s.env == nil {
+		fields := make([]schemaField, 0, len(s.names))
+		for _, name := range s.names {
+			fields = append(fields, schemaField{name: name, typ: s.types[name]})
+		}
+		s.env = reflect.New(structOf(fields)).Elem().Interface()
+	}
+	return s.env
+}
+
+// compile checks the expression against the scope.
+// The program is used only for the type checking, as the data is not the struct of the schema.
+// Names of the user environment which are not the data of the schema are merged into the scope.
+func (s *schemaScope) compile(expression string, opts []expr.Option, env any) (*Program, error) {
+	opts = append(opts[:len(opts):len(opts)], expr.Env(s.envValue()))
+	if env != nil {
+		opts = append(opts, func(c *conf.Config) {
+			for name, tag := range conf.CreateTypesTable(env) {
+				if _, ok := c.Types[name]; !ok {
+					c.Types[name] = tag
+				}
+			}
+		})
+	}
+	program, err := expr.Compile(expression, opts...)
+	if err != nil {
+		var fileErr *file.Error
+		if errors.As(err, &fileErr) {
+			fileErr.Message = s.typeNames().Replace(fileErr.Message)
+		}
+		return nil, err
+	}
+	return program, nil
+}
+
+// typeNames replaces names of struct types of the scope like `struct { F0 string "expr:\"name\"" }`
+// with names of data keys like `struct { name string }` in error messages
+func (s *schemaScope) typeNames() *strings.Replacer {
+	names := map[string]string{}
+	for _, t := range s.types {
+		collectSchemaTypeNames(t, names)
+	}
+	keys := make([]string, 0, len(names))
+	for key := range names {
+		keys = append(keys, key)
+	}
+	// Outer types go first, as they contain names of the nested types
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	pairs := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		pairs = append(pairs, key, names[key])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func collectSchemaTypeNames(t reflect.Type, names map[string]string) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		collectSchemaTypeNames(t.Elem(), names)
+	case reflect.Struct:
+		if t == timeType || t.Name() != "" {
+			return
+		}
+		if _, ok := names[t.String()]; ok {
+			return
+		}
+		names[t.String()] = schemaTypeName(t)
+		for i := 0; i < t.NumField(); i++ {
+			collectSchemaTypeNames(t.Field(i).Type, names)
+		}
+	}
+}
+
+// schemaTypeName returns the name of the type with data keys instead of struct field names
+func schemaTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + schemaTypeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + schemaTypeName(t.Elem())
+	case reflect.Array:
+		return "[" + strconv.Itoa(t.Len()) + "]" + schemaTypeName(t.Elem())
+	case reflect.Map:
+		return "map[" + schemaTypeName(t.Key()) + "]" + schemaTypeName(t.Elem())
+	case reflect.Struct:
+		if t == timeType || t.Name() != "" {
+			return t.String()
+		}
+		if t.NumField() == 0 {
+			return "struct {}"
+		}
+		fields := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fields = append(fields, strOrDef(field.Tag.Get("expr"), field.Name)+" "+schemaTypeName(field.Type))
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }"
+	}
+	return t.String()
+}
+
+func ctxWithSchema(ctx context.Context, scope *schemaScope) context.Context {
+	return context.WithValue(ctx, ctxSchemaKey, scope)
+}
+
+// ctxSchema returns the schema scope of the template node or nil if the template has no schema
+func ctxSchema(ctx context.Context) *schemaScope {
+	scope, _ := ctx.Value(ctxSchemaKey).(*schemaScope)
+	return scope
+}
+
+// ctxWithSchemaVars adds variables to the schema scope, the context is returned as is without the schema
+func ctxWithSchemaVars(ctx context.Context, vars ...schemaVar) context.Context {
+	if scope := ctxSchema(ctx); scope != nil && len(vars) > 0 {
+		return ctxWithSchema(ctx, scope.with(vars...))
+	}
+	return ctx
+}
+
+// schemaExprType returns the result type of the expression in the schema scope.
+// Invalid expressions have any type, as their errors are reported by the parser.
+func schemaExprType(ctx context.Context, expression string) reflect.Type {
+	scope := ctxSchema(ctx)
+	if scope == nil {
+		return nil
+	}
+	program, err := scope.compile(expression, exprCompileOptions(ctx), ctxGetOptions(ctx).exprEnv)
+	if err != nil || program.Node == nil || program.Node.Type() == nil {
+		return anyType
+	}
+	return program.Node.Type()
+}
+
+// schemaIterateVars returns typed loop variables of the iteration over the expression result
+func schemaIterateVars(ctx context.Context, expression, valueName, indexName, keyName string) []schemaVar {
+	t := schemaExprType(ctx, expression)
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	vars := []schemaVar{{name: strOrDef(indexName, "index"), typ: intType}}
+	valueName, keyName = strOrDef(valueName, "item"), strOrDef(keyName, "key")
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return append(vars, schemaVar{name: valueName, typ: t.Elem()})
+	case reflect.Map:
+		return append(vars, schemaVar{name: valueName, typ: t.Elem()}, schemaVar{name: keyName, typ: t.Key()})
+	case reflect.Struct:
+		return append(vars, schemaVar{name: valueName, typ: anyType}, schemaVar{name: keyName, typ: reflect.TypeOf("")})
+	}
+	return append(vars, schemaVar{name: valueName, typ: anyType}, schemaVar{name: keyName, typ: anyType})
+}
+
+// schemaAnyVars returns variables of unknown type
+func schemaAnyVars(names ...string) []schemaVar {
+	vars := make([]schemaVar, 0, len(names))
+	for _, name := range names {
+		vars = append(vars, schemaVar{name: name, typ: anyType})
+	}
+	return vars
+}
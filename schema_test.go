@@ -0,0 +1,217 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSchemaPerson struct {
+	Name string   `json:"name"`
+	Age  int      `json:"age"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+type testSchemaInput struct {
+	Name    string              `json:"name"`
+	Person  []*testSchemaPerson `json:"person"`
+	Labels  map[string]string   `json:"labels"`
+	Created time.Time           `json:"created"`
+	Extra   any                 `json:"extra"`
+	Secret  string              `json:"-"`
+}
+
+var testSchemaTemplate = map[string]any{
+	"name":    "{{upper(name)}}",
+	"created": "{{created.Year()}}",
+	"extra":   "{{extra?.anything?.goes}}",
+	"people": map[string]any{
+		"$iterate": "person",
+		"$where":   "item.age > 18",
+		"$body": map[string]any{
+			"name": "{{item.name}}-{{index}}",
+			"tags": map[string]any{"$iterate": "tag, i := item.tags", "$body": "{{tag}}:{{i}}:{{item.name}}"},
+		},
+	},
+	"labels": map[string]any{"$iterate": "labels", "$body": "{{key}}={{item}}"},
+	"count": map[string]any{
+		"$with": []any{"n := len(person)", "m := n * 2"},
+		"$body": "{{m + 1}}",
+	},
+	"first": map[string]any{
+		"$switch": "p := person[0]",
+		"$case":   []any{map[string]any{"$cond": "p.age >= 18", "$body": "{{p.name}}"}},
+	},
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := SchemaOf(testSchemaInput{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	tpl, err := NewTemplateFor(testSchemaTemplate, WithSchema(schema))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{
+		"name":    "app",
+		"created": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"person": []any{
+			map[string]any{"name": "tony", "age": 42, "tags": []any{"iron"}},
+			map[string]any{"name": "peter", "age": 16},
+		},
+		"labels": map[string]any{"env": "prod"},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{
+			"name":    "APP",
+			"created": 2024,
+			"extra":   nil,
+			"people":  []any{map[string]any{"name": "tony-0", "tags": []any{"iron:0:tony"}}},
+			"labels":  []any{"env=prod"},
+			"count":   5,
+			"first":   "tony",
+		}, res)
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	schema, err := SchemaOf(&testSchemaInput{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		tpl  any
+		msg  string
+		path string
+	}{
+		{tpl: map[string]any{"name": "{{persn.name}}"}, msg: "unknown name persn", path: "/name"},
+		{tpl: map[string]any{"name": "{{Secret}}"}, msg: "unknown name Secret", path: "/name"},
+		{tpl: map[string]any{"name": "{{name + 1}}"}, msg: "invalid operation", path: "/name"},
+		{
+			tpl:  map[string]any{"list": map[string]any{"$iterate": "person", "$body": "{{item.nmae}}"}},
+			msg:  "has no field nmae",
+			path: "/list/$body",
+		},
+		{
+			tpl:  map[string]any{"list": map[string]any{"$iterate": "person", "$sortBy": "item.weight desc", "$body": "{{item.name}}"}},
+			msg:  "has no field weight",
+			path: "/list/$sortBy",
+		},
+		{
+			tpl:  map[string]any{"list": map[string]any{"$iterate": "p := person", "$body": "{{item.name}}"}},
+			msg:  "unknown name item",
+			path: "/list/$body",
+		},
+		{
+			tpl:  map[string]any{"v": map[string]any{"$with": "p := person[0]", "$body": "{{p.age.x}}"}},
+			msg:  "int[string] is undefined",
+			path: "/v/$body",
+		},
+		{
+			tpl:  map[string]any{"v": map[string]any{"$if": "person[0].admin", "value": 1}},
+			msg:  "has no field admin",
+			path: "/v/$if",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			_, err := NewTemplateFor(test.tpl, WithSchema(schema))
+			var tplErr *TemplateError
+			if assert.True(t, errors.As(err, &tplErr), err) {
+				assert.Equal(t, test.path, tplErr.Path)
+				assert.Contains(t, err.Error(), test.msg)
+			}
+			_, err = NewTemplateFor(test.tpl)
+			assert.NoError(t, err, "without the schema")
+		})
+	}
+}
+
+func TestSchemaSources(t *testing.T) {
+	example, err := SchemaFromExample(map[string]any{
+		"name":    "app",
+		"person":  []any{map[string]any{"name": "tony", "age": 42, "tags": []any{"iron"}}},
+		"labels":  map[string]any{"env": "prod"},
+		"created": time.Now(),
+		"extra":   nil,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	jsonSchema, err := ParseJSONSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"person": {"type": "array", "items": {"type": "object", "properties": {
+				"name": {"type": "string"}, "age": {"type": ["integer", "null"]}, "tags": {"type": "array", "items": {"type": "string"}}
+			}}},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+			"created": {},
+			"extra": {}
+		}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for name, schema := range map[string]*Schema{"example": example, "json": jsonSchema} {
+		_, err := NewTemplateFor(testSchemaTemplate, WithSchema(schema))
+		assert.NoError(t, err, name)
+		_, err = NewTemplateFor(map[string]any{"v": "{{person[0].nmae}}"}, WithSchema(schema))
+		assert.Error(t, err, name)
+	}
+
+	_, err = SchemaFromExample([]any{1})
+	assert.ErrorIs(t, err, errInvalidSchema)
+	_, err = ParseJSONSchema([]byte(`{"type": "string"}`))
+	assert.ErrorIs(t, err, errInvalidSchema)
+}
+
+func TestSchemaMacrosAndIncludes(t *testing.T) {
+	schema, err := SchemaOf(testSchemaInput{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	set := NewTemplateSet(WithSchema(schema)).
+		Add("main", map[string]any{
+			"$define": map[string]any{"name": "greet", "params": []any{"who"}, "body": "{{who}} from {{name}}"},
+			"greet":   map[string]any{"$call": "greet", "$args": []any{"{{person[0].name}}"}},
+			"part":    map[string]any{"$include": "part", "$with": map[string]any{"title": "{{name}}"}},
+		}).
+		Add("part", map[string]any{"title": "{{title}}", "owner": "{{person[0].name}}"}).
+		Add("broken", map[string]any{"part": map[string]any{"$include": "part"}})
+
+	_, err = set.Template("main")
+	assert.NoError(t, err)
+	_, err = set.Template("broken")
+	assert.ErrorContains(t, err, "unknown name title")
+}
+
+func TestSchemaExprEnv(t *testing.T) {
+	schema, err := SchemaOf(testSchemaInput{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	env := map[string]any{"up": strings.ToUpper}
+	tpl, err := NewTemplateFor(map[string]any{"name": "{{up(name)}}"}, WithExprEnv(env), WithSchema(schema))
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"name": "app", "up": strings.ToUpper})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"name": "APP"}, res)
+	}
+
+	_, err = NewTemplateFor(map[string]any{"name": "{{up(nmae)}}"}, WithExprEnv(env), WithSchema(schema))
+	assert.ErrorContains(t, err, "unknown name nmae")
+
+	_, err = NewTemplateFor(map[string]any{"name": "{{person[0].nmae}}"}, WithSchema(schema))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "type struct { name string; age int; tags []string } has no field nmae")
+		assert.NotContains(t, err.Error(), "F0")
+	}
+}
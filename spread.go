@@ -0,0 +1,87 @@
+package datatemplate
+
+import (
+	"context"
+
+	"github.com/demdxx/gocast/v2"
+	"github.com/pkg/errors"
+)
+
+var errInvalidSpreadValue = errors.New("invalid spread value")
+
+// SpreadBlock marks the block which result must be merged into the enclosing
+// list or map instead of being nested into it.
+//
+// Example 1 (list):
+// - first
+// - $spread:
+//
+//	$iterate: "items"
+//	$body: "{{item.name}}"
+//
+// - last
+//
+// Example 2 (map):
+// name: "{{service.name}}"
+// $...: "{{service.labels}}"
+type SpreadBlock struct {
+	blockSource
+	block Block
+}
+
+func NewSpreadBlock(block Block) *SpreadBlock {
+	return &SpreadBlock{block: block}
+}
+
+func (b *SpreadBlock) String() string {
+	return "$spread: " + b.block.String()
+}
+
+func (b *SpreadBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	return b.block.Emit(ctx, data)
+}
+
+// emitList returns the list of elements which must be spliced into the parent list
+func (b *SpreadBlock) emitList(ctx context.Context, data map[string]any) ([]any, error) {
+	res, err := b.Emit(ctx, data)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	if !gocast.IsSlice(res) {
+		return nil, b.wrapError(errors.Wrap(errInvalidSpreadValue, "list expected"))
+	}
+	return gocast.AnySlice[any](res), nil
+}
+
+// emitMap returns the map of values which must be merged into the parent map,
+// if the result is a list then all its maps are merged one by one
+func (b *SpreadBlock) emitMap(ctx context.Context, data map[string]any) (*OrderedMap, error) {
+	res, err := b.Emit(ctx, data)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	switch {
+	case isMapData(res):
+		return toOrderedMap(res), nil
+	case gocast.IsSlice(res):
+		merged := NewOrderedMap()
+		for _, item := range gocast.AnySlice[any](res) {
+			if item == nil {
+				continue
+			}
+			if !isMapData(item) {
+				return nil, b.wrapError(errors.Wrap(errInvalidSpreadValue, "map expected"))
+			}
+			mp := toOrderedMap(item)
+			for _, key := range mp.keys {
+				merged.Set(key, mp.values[key])
+			}
+		}
+		return merged, nil
+	}
+	return nil, b.wrapError(errors.Wrap(errInvalidSpreadValue, "map expected"))
+}
+
+func isSpreadKey(key string) bool {
+	return key == "$spread" || key == "$..."
+}
@@ -0,0 +1,52 @@
+package datatemplate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpreadBlock(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"items":  []any{"b", "c"},
+		"labels": map[string]any{"app": "web", "name": "label"},
+	}
+
+	t.Run("list", func(t *testing.T) {
+		block := &DataBlockSlice{data: []any{"a", NewSpreadBlock(tNewExpr(ctx, "{{items}}")), "d"}}
+		res, err := block.Emit(ctx, data)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"a", "b", "c", "d"}, res)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		block := &DataBlockMap{data: map[string]any{
+			"name":    "service",
+			"$spread": NewSpreadBlock(tNewExpr(ctx, "{{labels}}")),
+		}}
+		res, err := block.Emit(ctx, data)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{"name": "service", "app": "web"}, res)
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		block := &DataBlockMap{data: map[string]any{
+			"kind":    "Service",
+			"$spread": NewSpreadBlock(tNewExpr(ctx, "{{labels}}")),
+			"name":    "service",
+		}, order: []string{"kind", "$spread", "name"}, ordered: true}
+		res, err := block.Emit(ctx, data)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"kind", "app", "name"}, res.(*OrderedMap).Keys())
+			assert.Equal(t, "service", res.(*OrderedMap).Get("name"))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		block := &DataBlockSlice{data: []any{NewSpreadBlock(tNewExpr(ctx, "{{labels}}"))}}
+		_, err := block.Emit(ctx, data)
+		assert.ErrorIs(t, err, errInvalidSpreadValue)
+	})
+}
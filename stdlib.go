@@ -0,0 +1,387 @@
+package datatemplate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/antonmedv/expr"
+	"github.com/demdxx/gocast/v2"
+)
+
+// stdFuncOptions is the standard function library of template expressions.
+// It extends the builtins of expr (trim, upper, split, join, toJSON, toBase64, etc.),
+// which stay available when the library is disabled by WithStdFuncs(false),
+// and functions take the processed value as the first argument to work with pipes: `{{name | snakeCase()}}`
+var stdFuncOptions = []expr.Option{
+	expr.Function("parseURI", stdParseURI,
+		new(func(string) map[string]any), new(func([]string) []any), new(func([]any) []any)),
+
+	expr.Function("titleCase", stdStringFunc("titleCase", titleCase), new(func(string) string)),
+	expr.Function("camelCase", stdStringFunc("camelCase", camelCase), new(func(string) string)),
+	expr.Function("snakeCase", stdStringFunc("snakeCase", func(s string) string { return joinWords(s, "_") }), new(func(string) string)),
+	expr.Function("kebabCase", stdStringFunc("kebabCase", func(s string) string { return joinWords(s, "-") }), new(func(string) string)),
+
+	expr.Function("regexMatch", stdRegexMatch, new(func(string, string) bool)),
+	expr.Function("regexFind", stdRegexFind, new(func(string, string) string)),
+	expr.Function("regexFindAll", stdRegexFindAll, new(func(string, string) []any)),
+	expr.Function("regexReplace", stdRegexReplace, new(func(string, string, string) string)),
+
+	expr.Function("toHex", stdToHex),
+	expr.Function("fromHex", stdFromHex, new(func(string) string)),
+	expr.Function("sha256", stdSHA256),
+
+	expr.Function("default", stdDefault, new(func(any, any) any)),
+	expr.Function("coalesce", stdCoalesce),
+
+	expr.Function("formatNumber", stdFormatNumber,
+		new(func(any, int) string), new(func(any, int, string) string)),
+}
+
+func stdStringFunc(name string, fn func(string) string) func(params ...any) (any, error) {
+	return func(params ...any) (any, error) {
+		s, err := stdStrArg(name, params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s), nil
+	}
+}
+
+// stdStrArg converts the i-th argument of the function into string,
+// nil is the empty string and scalar values are formatted
+func stdStrArg(name string, params []any, i int) (string, error) {
+	switch v := params[i].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	switch reflect.ValueOf(params[i]).Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return gocast.Str(params[i]), nil
+	}
+	return "", fmt.Errorf("%s: argument %d must be a string, got %T", name, i+1, params[i])
+}
+
+// stdIntArg converts the i-th argument of the function into integer
+func stdIntArg(name string, params []any, i int) (int, error) {
+	num, err := gocast.TryNumber[float64](params[i])
+	if params[i] == nil || err != nil || num != float64(int(num)) {
+		return 0, fmt.Errorf("%s: argument %d must be an integer, got %T", name, i+1, params[i])
+	}
+	return int(num), nil
+}
+
+// stdParseURI parses URI into the map with scheme, username, password, host, port, path, query and fragment,
+// the list of URIs is parsed into the list of maps
+func stdParseURI(params ...any) (any, error) {
+	if _, ok := params[0].([]byte); !ok && params[0] != nil && gocast.IsSlice(params[0]) {
+		items := reflect.ValueOf(params[0])
+		res := make([]any, 0, items.Len())
+		for i := 0; i < items.Len(); i++ {
+			uri, err := stdStrArg("parseURI", []any{items.Index(i).Interface()}, 0)
+			if err != nil {
+				return nil, fmt.Errorf("parseURI: item %d must be a string, got %T", i, items.Index(i).Interface())
+			}
+			u, err := parseURI(uri)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, u)
+		}
+		return res, nil
+	}
+	uri, err := stdStrArg("parseURI", params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseURI(uri)
+}
+
+func parseURI(uri string) (map[string]any, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	query := make(map[string]any, len(u.Query()))
+	for key, values := range u.Query() {
+		query[key] = values[0]
+	}
+	var port any
+	if p := u.Port(); p != "" {
+		if n, err := strconv.Atoi(p); err == nil {
+			port = n
+		} else {
+			port = p
+		}
+	}
+	password, _ := u.User.Password()
+	return map[string]any{
+		"scheme":   u.Scheme,
+		"username": u.User.Username(),
+		"password": password,
+		"host":     u.Hostname(),
+		"port":     port,
+		"path":     u.Path,
+		"query":    query,
+		"fragment": u.Fragment,
+	}, nil
+}
+
+// splitWords splits the string by non-alphanumeric characters and by case changes like "userID" -> [user, ID]
+func splitWords(s string) []string {
+	var (
+		words []string
+		word  []rune
+		runes = []rune(s)
+	)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words, word = append(words, string(word)), nil
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextLower {
+				words, word = append(words, string(word)), nil
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+func joinWords(s, sep string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, sep)
+}
+
+func upperFirst(s string) string {
+	runes := []rune(strings.ToLower(s))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+func titleCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		words[i] = upperFirst(word)
+	}
+	return strings.Join(words, " ")
+}
+
+func camelCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word)
+		} else {
+			words[i] = upperFirst(word)
+		}
+	}
+	return strings.Join(words, "")
+}
+
+var regexCache sync.Map
+
+// compileRegex returns the cached regular expression, as patterns are usually constants of the template
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// stdRegexArgs returns the string and the regular expression of regex functions
+func stdRegexArgs(name string, params []any) (string, *regexp.Regexp, error) {
+	str, err := stdStrArg(name, params, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	pattern, err := stdStrArg(name, params, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	re, err := compileRegex(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	return str, re, nil
+}
+
+func stdRegexMatch(params ...any) (any, error) {
+	str, re, err := stdRegexArgs("regexMatch", params)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(str), nil
+}
+
+func stdRegexFind(params ...any) (any, error) {
+	str, re, err := stdRegexArgs("regexFind", params)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindString(str), nil
+}
+
+func stdRegexFindAll(params ...any) (any, error) {
+	str, re, err := stdRegexArgs("regexFindAll", params)
+	if err != nil {
+		return nil, err
+	}
+	matches := re.FindAllString(str, -1)
+	res := make([]any, len(matches))
+	for i, match := range matches {
+		res[i] = match
+	}
+	return res, nil
+}
+
+func stdRegexReplace(params ...any) (any, error) {
+	str, re, err := stdRegexArgs("regexReplace", params)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stdStrArg("regexReplace", params, 2)
+	if err != nil {
+		return nil, err
+	}
+	return re.ReplaceAllString(str, replacement), nil
+}
+
+func stdToHex(params ...any) (any, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("toHex: expected 1 argument, got %d", len(params))
+	}
+	if b, ok := params[0].([]byte); ok {
+		return hex.EncodeToString(b), nil
+	}
+	return hex.EncodeToString([]byte(gocast.Str(params[0]))), nil
+}
+
+func stdFromHex(params ...any) (any, error) {
+	str, err := stdStrArg("fromHex", params, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func stdSHA256(params ...any) (any, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("sha256: expected 1 argument, got %d", len(params))
+	}
+	var data []byte
+	if b, ok := params[0].([]byte); ok {
+		data = b
+	} else {
+		data = []byte(gocast.Str(params[0]))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isEmptyValue returns true for nil, empty strings, slices and maps
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// stdDefault returns the default value if the value is empty: `{{port | default(8080)}}`
+func stdDefault(params ...any) (any, error) {
+	if isEmptyValue(params[0]) {
+		return params[1], nil
+	}
+	return params[0], nil
+}
+
+// stdCoalesce returns the first non empty value
+func stdCoalesce(params ...any) (any, error) {
+	for _, v := range params {
+		if !isEmptyValue(v) {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// stdFormatNumber formats the number with the fixed precision and the optional thousands separator
+func stdFormatNumber(params ...any) (any, error) {
+	num, err := gocast.TryNumber[float64](params[0])
+	if err != nil {
+		return nil, fmt.Errorf("formatNumber: argument 1 must be a number, got %T", params[0])
+	}
+	precision, err := stdIntArg("formatNumber", params, 1)
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if len(params) > 2 {
+		if sep, err = stdStrArg("formatNumber", params, 2); err != nil {
+			return nil, err
+		}
+	}
+	res := strconv.FormatFloat(num, 'f', precision, 64)
+	if sep == "" {
+		return res, nil
+	}
+	sign, intPart, fracPart := "", res, ""
+	if strings.HasPrefix(intPart, "-") {
+		sign, intPart = "-", intPart[1:]
+	}
+	if i := strings.IndexByte(intPart, '.'); i >= 0 {
+		intPart, fracPart = intPart[:i], intPart[i:]
+	}
+	var buf strings.Builder
+	buf.WriteString(sign)
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteString(fracPart)
+	return buf.String(), nil
+}
@@ -0,0 +1,255 @@
+package datatemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/demdxx/gocast/v2"
+)
+
+// strftimeLayouts maps strftime directives to Go layout elements
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'Z': "MST", 'z': "-0700",
+	'F': "2006-01-02", 'T': "15:04:05", 'D': "01/02/06", 'R': "15:04",
+	'%': "%",
+}
+
+// stdLayoutArg converts the i-th argument of the function into Go time layout
+func stdLayoutArg(name string, params []any, i int) (string, error) {
+	layout, err := stdStrArg(name, params, i)
+	if err != nil {
+		return "", err
+	}
+	return timeLayout(layout)
+}
+
+// timeLayout converts strftime layout like "%Y-%m-%d" into Go layout,
+// the layout without `%` is used as Go layout as is
+func timeLayout(layout string) (string, error) {
+	if !strings.Contains(layout, "%") {
+		return layout, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			buf.WriteByte(layout[i])
+			continue
+		}
+		if i++; i >= len(layout) {
+			return "", fmt.Errorf("invalid time layout %q: trailing %%", layout)
+		}
+		elem, ok := strftimeLayouts[layout[i]]
+		if !ok {
+			return "", fmt.Errorf("invalid time layout %q: unsupported directive %%%c", layout, layout[i])
+		}
+		buf.WriteString(elem)
+	}
+	return buf.String(), nil
+}
+
+// stdTimeArg converts the i-th argument of the function into time,
+// the argument is time.Time, RFC3339 string or unix timestamp in seconds
+func stdTimeArg(name string, params []any, i int) (time.Time, error) {
+	switch t := params[i].(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t != nil {
+			return *t, nil
+		}
+	}
+	if num, ok := stdNumberArg(params[i]); ok {
+		sec := math.Floor(num)
+		return time.Unix(int64(sec), int64((num-sec)*float64(time.Second))).UTC(), nil
+	}
+	if params[i] != nil {
+		if s, err := stdStrArg(name, params, i); err == nil {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%s: argument %d: %w", name, i+1, err)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s: argument %d must be a time, got %T", name, i+1, params[i])
+}
+
+// stdDurationArg converts the i-th argument of the function into duration,
+// the argument is time.Duration, duration string like "1h30m" or number of seconds
+func stdDurationArg(name string, params []any, i int) (time.Duration, error) {
+	if d, ok := params[i].(time.Duration); ok {
+		return d, nil
+	}
+	if num, ok := stdNumberArg(params[i]); ok {
+		return time.Duration(num * float64(time.Second)), nil
+	}
+	if params[i] != nil {
+		if s, err := stdStrArg(name, params, i); err == nil {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return 0, fmt.Errorf("%s: argument %d: %w", name, i+1, err)
+			}
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: argument %d must be a duration, got %T", name, i+1, params[i])
+}
+
+// stdLocationArg loads the timezone by the name of the i-th argument of the function
+func stdLocationArg(name string, params []any, i int) (*time.Location, error) {
+	tz, err := stdStrArg(name, params, i)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("%s: argument %d: %w", name, i+1, err)
+	}
+	return loc, nil
+}
+
+// stdNumberArg returns the value of numbers including json.Number, numeric strings are not numbers
+func stdNumberArg(v any) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		num, err := n.Float64()
+		return num, err == nil
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return gocast.Number[float64](v), true
+	}
+	return 0, false
+}
+
+func argsCount(name string, params []any, minCount, maxCount int) error {
+	if len(params) < minCount || len(params) > maxCount {
+		if minCount == maxCount {
+			return fmt.Errorf("%s: expected %d arguments, got %d", name, minCount, len(params))
+		}
+		return fmt.Errorf("%s: expected %d to %d arguments, got %d", name, minCount, maxCount, len(params))
+	}
+	return nil
+}
+
+// nowFuncOptions replaces the builtin `now()` with the function returning the time of the clock
+func nowFuncOptions(clock func() time.Time) []expr.Option {
+	if clock == nil {
+		clock = time.Now
+	}
+	return []expr.Option{
+		expr.DisableBuiltin("now"),
+		expr.Function("now", func(params ...any) (any, error) {
+			return clock(), nil
+		}, new(func() time.Time)),
+	}
+}
+
+// timeFuncOptions returns date and time functions of the standard library
+func timeFuncOptions(clock func() time.Time) []expr.Option {
+	return append(nowFuncOptions(clock),
+		// parseTime(value, [layout, [timezone]])
+		expr.Function("parseTime", func(params ...any) (any, error) {
+			if err := argsCount("parseTime", params, 1, 3); err != nil {
+				return nil, err
+			}
+			if len(params) == 1 {
+				return stdTimeArg("parseTime", params, 0)
+			}
+			value, err := stdStrArg("parseTime", params, 0)
+			if err != nil {
+				return nil, err
+			}
+			layout, err := stdLayoutArg("parseTime", params, 1)
+			if err != nil {
+				return nil, err
+			}
+			loc := time.UTC
+			if len(params) == 3 {
+				if loc, err = stdLocationArg("parseTime", params, 2); err != nil {
+					return nil, err
+				}
+			}
+			return time.ParseInLocation(layout, value, loc)
+		}),
+		// formatTime(time, layout, [timezone])
+		expr.Function("formatTime", func(params ...any) (any, error) {
+			if err := argsCount("formatTime", params, 2, 3); err != nil {
+				return nil, err
+			}
+			t, err := stdTimeArg("formatTime", params, 0)
+			if err != nil {
+				return nil, err
+			}
+			layout, err := stdLayoutArg("formatTime", params, 1)
+			if err != nil {
+				return nil, err
+			}
+			if len(params) == 3 {
+				loc, err := stdLocationArg("formatTime", params, 2)
+				if err != nil {
+					return nil, err
+				}
+				t = t.In(loc)
+			}
+			return t.Format(layout), nil
+		}),
+		// addDuration(time, duration)
+		expr.Function("addDuration", func(params ...any) (any, error) {
+			if err := argsCount("addDuration", params, 2, 2); err != nil {
+				return nil, err
+			}
+			t, err := stdTimeArg("addDuration", params, 0)
+			if err != nil {
+				return nil, err
+			}
+			d, err := stdDurationArg("addDuration", params, 1)
+			if err != nil {
+				return nil, err
+			}
+			return t.Add(d), nil
+		}),
+		// truncate(time, duration), the day truncation is in the timezone of the time
+		expr.Function("truncate", func(params ...any) (any, error) {
+			if err := argsCount("truncate", params, 2, 2); err != nil {
+				return nil, err
+			}
+			t, err := stdTimeArg("truncate", params, 0)
+			if err != nil {
+				return nil, err
+			}
+			d, err := stdDurationArg("truncate", params, 1)
+			if err != nil {
+				return nil, err
+			}
+			if d == 24*time.Hour {
+				return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+			}
+			return t.Truncate(d), nil
+		}),
+		// inTimezone(time, timezone)
+		expr.Function("inTimezone", func(params ...any) (any, error) {
+			if err := argsCount("inTimezone", params, 2, 2); err != nil {
+				return nil, err
+			}
+			t, err := stdTimeArg("inTimezone", params, 0)
+			if err != nil {
+				return nil, err
+			}
+			loc, err := stdLocationArg("inTimezone", params, 1)
+			if err != nil {
+				return nil, err
+			}
+			return t.In(loc), nil
+		}),
+	)
+}
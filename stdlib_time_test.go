@@ -0,0 +1,124 @@
+package datatemplate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeFuncs(t *testing.T) {
+	clock := func() time.Time { return time.Date(2024, 3, 15, 22, 30, 45, 0, time.UTC) }
+	tests := []struct {
+		expr   string
+		result any
+	}{
+		{expr: "now()", result: clock()},
+		{expr: "now().Year()", result: 2024},
+		{expr: "formatTime(now(), '%Y-%m-%d %H:%M:%S')", result: "2024-03-15 22:30:45"},
+		{expr: "formatTime(now(), time.DateOnly)", result: "2024-03-15"},
+		{expr: "now() | formatTime('%F %%', 'Asia/Tokyo')", result: "2024-03-16 %"},
+		{expr: "formatTime(now(), '%a, %d %b %Y %T %z', 'Europe/Berlin')", result: "Fri, 15 Mar 2024 23:30:45 +0100"},
+		{expr: "parseTime('2024-03-15T10:00:00Z')", result: time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)},
+		{expr: "parseTime('15.03.2024', '%d.%m.%Y')", result: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "formatTime(parseTime('2024-03-15 10:00', '2006-01-02 15:04', 'America/New_York'), time.RFC3339)", result: "2024-03-15T10:00:00-04:00"},
+		{expr: "formatTime(addDuration(now(), '-1h30m'), '%R')", result: "21:00"},
+		{expr: "formatTime(addDuration(now(), 90), '%T')", result: "22:32:15"},
+		{expr: "formatTime(truncate(now(), '1h'), '%T')", result: "22:00:00"},
+		{expr: "formatTime(truncate(inTimezone(now(), 'Asia/Tokyo'), '24h'), time.RFC3339)", result: "2024-03-16T00:00:00+09:00"},
+		{expr: "formatTime(1710541845, '%F')", result: "2024-03-15"},
+		{expr: "now() - parseTime('2024-03-15T22:00:00Z') > duration('10m')", result: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			tpl, err := NewTemplateFor("{{"+test.expr+"}}", WithClock(clock))
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := tpl.Process(context.Background(), map[string]any{
+				"time": map[string]string{"DateOnly": time.DateOnly, "RFC3339": time.RFC3339},
+			})
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.result, res)
+			}
+		})
+	}
+}
+
+func TestTimeFuncsErrors(t *testing.T) {
+	for _, expr := range []string{
+		"formatTime(now(), '%Q')",
+		"parseTime('yesterday')",
+		"inTimezone(now(), 'Mars/Olympus')",
+		"addDuration(now(), 'soon')",
+		"formatTime(now())",
+	} {
+		tpl, err := NewTemplateFor("{{" + expr + "}}")
+		if !assert.NoError(t, err, expr) {
+			continue
+		}
+		_, err = tpl.Process(context.Background(), nil)
+		assert.Error(t, err, expr)
+	}
+
+	pinned := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tpl, err := NewTemplateFor("{{now()}}", WithStdFuncs(false), WithClock(func() time.Time { return pinned }))
+	if assert.NoError(t, err) {
+		res, err := tpl.Process(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, pinned, res)
+	}
+}
+
+type testTimezone string
+
+func TestTimeFuncsDynamicArgs(t *testing.T) {
+	data := map[string]any{
+		"created": []byte("2024-03-15T10:00:00Z"),
+		"tz":      testTimezone("Asia/Tokyo"),
+		"tzBytes": []byte("Europe/Berlin"),
+		"ts":      json.Number("1710541845"),
+		"timeout": 90,
+		"half":    1.5,
+		"list":    []any{"UTC"},
+	}
+	tests := []struct {
+		expr   string
+		result any
+		error  string
+	}{
+		{expr: "formatTime(created, '%F %T')", result: "2024-03-15 10:00:00"},
+		{expr: "formatTime(created, '%F %T', tz)", result: "2024-03-15 19:00:00"},
+		{expr: "formatTime(inTimezone(created, tzBytes), '%T')", result: "11:00:00"},
+		{expr: "parseTime(created, '2006-01-02T15:04:05Z', tz)", result: time.Date(2024, 3, 15, 10, 0, 0, 0, time.FixedZone("JST", 9*3600))},
+		{expr: "formatTime(ts, '%F')", result: "2024-03-15"},
+		{expr: "formatTime(addDuration(created, timeout), '%T')", result: "10:01:30"},
+		{expr: "formatTime(addDuration(created, half), '%T')", result: "10:00:01"},
+		{expr: "inTimezone(created, list)", error: "inTimezone: argument 2 must be a string, got []interface {}"},
+		{expr: "addDuration(created, list)", error: "addDuration: argument 2 must be a duration, got []interface {}"},
+		{expr: "formatTime(list, '%F')", error: "formatTime: argument 1 must be a time, got []interface {}"},
+		{expr: "inTimezone(created, 'Mars/Olympus')", error: "inTimezone: argument 2: unknown time zone Mars/Olympus"},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			tpl, err := NewTemplateFor("{{" + test.expr + "}}")
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := tpl.Process(context.Background(), data)
+			if test.error != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.error)
+				}
+			} else if assert.NoError(t, err) {
+				if tm, ok := test.result.(time.Time); ok {
+					assert.True(t, tm.Equal(res.(time.Time)), res)
+				} else {
+					assert.Equal(t, test.result, res)
+				}
+			}
+		})
+	}
+}
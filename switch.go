@@ -0,0 +1,176 @@
+package datatemplate
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/demdxx/gocast/v2"
+	"github.com/demdxx/xtypes"
+	"github.com/pkg/errors"
+)
+
+// SwitchCase represents one branch of the switch block.
+// The case matches if the subject is equal to one of the values
+// or if the condition is true.
+type SwitchCase struct {
+	Values []any
+	Cond   *Program
+	Body   Block
+}
+
+func (c *SwitchCase) String() string {
+	var buf strings.Builder
+	buf.WriteString("{")
+	if c.Cond != nil {
+		buf.WriteString("`$cond`: `" + c.Cond.Source.Content() + "`")
+	} else {
+		buf.WriteString("$value: ")
+		if len(c.Values) == 1 {
+			buf.WriteString(gocast.Str(c.Values[0]))
+		} else {
+			buf.WriteString("[")
+			for i, v := range c.Values {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				buf.WriteString(gocast.Str(v))
+			}
+			buf.WriteString("]")
+		}
+	}
+	buf.WriteString(", $then: ")
+	if c.Body != nil {
+		buf.WriteString(c.Body.String())
+	} else {
+		buf.WriteString("null")
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func (c *SwitchCase) match(ctx context.Context, subject any, data map[string]any) (bool, error) {
+	if c.Cond != nil {
+		res, err := runExpr(ctx, c.Cond, data)
+		if err != nil {
+			return false, err
+		}
+		return gocast.Bool(res), nil
+	}
+	for _, v := range c.Values {
+		if equalValues(subject, v) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type SwitchBlock struct {
+	blockSource
+	name         string
+	expr         *Program
+	cases        []*SwitchCase
+	defaultBlock Block
+}
+
+func NewSwitchBlock(name string, expr *Program, cases []*SwitchCase, defaultBlock Block) *SwitchBlock {
+	return &SwitchBlock{
+		name:         name,
+		expr:         expr,
+		cases:        cases,
+		defaultBlock: defaultBlock,
+	}
+}
+
+func NewSwitchBlockFromExpr(ctx context.Context, name, expression string, cases []*SwitchCase, defaultBlock Block) (*SwitchBlock, error) {
+	program, err := compileExpr(ctx, expression)
+	if err != nil {
+		return nil, errors.Wrap(err, expression)
+	}
+	return NewSwitchBlock(name, program, cases, defaultBlock), nil
+}
+
+func (b *SwitchBlock) String() string {
+	var buf strings.Builder
+	buf.WriteString("$switch: {`$expr`: `")
+	if b.name != "" {
+		buf.WriteString(b.name + " := ")
+	}
+	buf.WriteString(b.expr.Source.Content() + "`, $case: [")
+	for i, c := range b.cases {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(c.String())
+	}
+	buf.WriteString("]")
+	if b.defaultBlock != nil {
+		buf.WriteString(", $default: " + b.defaultBlock.String())
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func (b *SwitchBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
+	subject, err := runExpr(ctx, b.expr, data)
+	if err != nil {
+		return nil, b.wrapError(err)
+	}
+	// The subject is bound only by the name like `$switch: p := person`
+	newData := data
+	if b.name != "" {
+		newData = xtypes.Map[string, any](data).Copy().Set(b.name, subject)
+	}
+	for _, c := range b.cases {
+		ok, err := c.match(ctx, subject, newData)
+		if err != nil {
+			return nil, b.wrapError(err)
+		}
+		if ok {
+			if c.Body == nil {
+				return nil, nil
+			}
+			return c.Body.Emit(ctx, newData)
+		}
+	}
+	if b.defaultBlock == nil {
+		return nil, nil
+	}
+	return b.defaultBlock.Emit(ctx, newData)
+}
+
+// equalValues compares two values, scalars are compared by their string representation
+// so the case key "80" matches the subject value 80
+func equalValues(a, b any) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	if isScalar(a) && isScalar(b) {
+		return gocast.Str(a) == gocast.Str(b)
+	}
+	return false
+}
+
+func isScalar(v any) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isNumber(v any) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
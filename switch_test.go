@@ -0,0 +1,68 @@
+package datatemplate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitchBlock(t *testing.T) {
+	ctx := context.Background()
+
+	cond, err := compileExpr(ctx, "value > 1024")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_switch, err := NewSwitchBlockFromExpr(ctx, "value", "port", []*SwitchCase{
+		{Values: []any{80, "8080"}, Body: NewDataBlock("http")},
+		{Values: []any{443}, Body: NewDataBlock("https")},
+		{Cond: cond, Body: NewDataBlock("custom")},
+	}, NewDataBlock("system"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "$switch: {`$expr`: `value := port`, $case: [{$value: [80, 8080], $then: http}, "+
+		"{$value: 443, $then: https}, {`$cond`: `value > 1024`, $then: custom}], $default: system}", _switch.String())
+
+	tests := []struct {
+		port any
+		res  any
+	}{
+		{port: 80, res: "http"},
+		{port: 8080, res: "http"},
+		{port: "443", res: "https"},
+		{port: 3000, res: "custom"},
+		{port: 22, res: "system"},
+	}
+	for _, test := range tests {
+		res, err := _switch.Emit(ctx, map[string]any{"port": test.port})
+		assert.NoError(t, err)
+		assert.Equal(t, test.res, res)
+	}
+}
+
+func TestSwitchBlockUnexpectedFields(t *testing.T) {
+	tests := []map[string]any{
+		{"$switch": "port", "$case": map[string]any{"80": "http"}, "extra": 1},
+		{"$switch": map[string]any{"$expr": "port", "$case": map[string]any{"80": "http"}}, "extra": 1},
+		{"$switch": map[string]any{"$expr": "port", "$case": map[string]any{"80": "http"}, "extra": 1}},
+	}
+	for _, tpl := range tests {
+		_, err := NewTemplateFor(tpl)
+		if assert.ErrorIs(t, err, errInvalidSwitchBlock) {
+			assert.Equal(t, "/$switch: unexpected field extra: invalid switch block", err.Error())
+		}
+	}
+}
+
+func TestSwitchBlockNoName(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{"$switch": "x", "$case": map[string]any{"1": "one"}, "$default": "{{value}}"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "$switch: {`$expr`: `x`, $case: [{$value: 1, $then: one}], $default: `value`}", tpl.String())
+	res, err := tpl.Process(context.Background(), map[string]any{"x": 2, "value": "mine"})
+	assert.NoError(t, err)
+	assert.Equal(t, "mine", res)
+}
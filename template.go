@@ -5,6 +5,9 @@ package datatemplate
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"github.com/demdxx/xtypes"
 )
 
 type Block interface {
@@ -13,32 +16,83 @@ type Block interface {
 }
 
 type Template struct {
-	root Block
+	root    Block
+	timeout time.Duration
+	limits  Limits
+
+	// ctxFuncs is true if expressions call functions with the context argument
+	ctxFuncs bool
 }
 
 // NewTemplate creates new template from root block
 func NewTemplate(root Block) *Template {
+	// The empty document like `null` is the template of nil
+	if root == nil {
+		root = &DataBlock{}
+	}
 	return &Template{root: root}
 }
 
-// NewTemplateFor creates new template from data input (string, map, struct, etc)
+// NewTemplateFor creates new template from data input (string, map, struct, etc).
+// If the data is *yaml.Node or *OrderedMap then the key order of the source is preserved
+// and maps are emitted as *OrderedMap.
+// Errors of the parse and the processing are returned as *TemplateError with the path of the node.
 func NewTemplateFor(data any, opts ...Option) (*Template, error) {
 	var opt options
 	for _, o := range opts {
 		o(&opt)
 	}
-	root, err := parseBlocks(ctxWithExprOptions(context.Background(), opt.exprOpts...), data)
+	opt.directives = mergeDirectives(opt.customDirectives)
+	funcOpts, ctxFuncs, err := exprFuncOptions(opt.funcs)
 	if err != nil {
 		return nil, err
 	}
-	return NewTemplate(NewDataBlock(root)), nil
+	exprOpts := append(opt.exprOpts[:len(opt.exprOpts):len(opt.exprOpts)], funcOpts...)
+	ctx := ctxWithOptions(ctxWithExprOptions(context.Background(), exprOpts...), &opt)
+	if opt.source != nil {
+		ctx = ctxWithSourceMap(ctx, opt.source)
+	}
+	if opt.schema != nil {
+		ctx = ctxWithSchema(ctx, opt.schema.rootScope())
+	}
+	var errs *TemplateErrors
+	if opt.allErrors {
+		errs = &TemplateErrors{}
+		ctx = context.WithValue(ctx, ctxParseErrorsKey, errs)
+	}
+	root, err := parseBlocks(ctx, data)
+	if err = parseError(ctx, err); err != nil {
+		return nil, err
+	}
+	if errs != nil && len(errs.Errors) > 0 {
+		return nil, errs
+	}
+	tpl := NewTemplate(NewDataBlock(root))
+	tpl.timeout = opt.timeout
+	tpl.limits = opt.limits
+	tpl.ctxFuncs = ctxFuncs
+	return tpl, nil
 }
 
 func (tpl *Template) String() string {
 	return tpl.root.String()
 }
 
-// Process template with data and return result according to template of data
+// Process template with data and return result according to template of data.
+// The processing is stopped with *CanceledError if the context is canceled or the timeout is exceeded,
+// and with *LimitError if any of the template limits is exceeded.
 func (tpl *Template) Process(ctx context.Context, data map[string]any) (any, error) {
+	if tpl.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tpl.timeout)
+		defer cancel()
+	}
+	if !tpl.limits.empty() {
+		ctx = ctxWithRenderState(ctx, tpl.limits)
+	}
+	if tpl.ctxFuncs {
+		data = xtypes.Map[string, any](data).Copy()
+		data[ctxDataKey] = ctx
+	}
 	return tpl.root.Emit(ctx, data)
 }
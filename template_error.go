@@ -0,0 +1,238 @@
+package datatemplate
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateError describes the error of the template node.
+// It's returned by the parser and by the template processing, and can be extracted with errors.As
+type TemplateError struct {
+	// Path of the template node in the JSON pointer format, like: /services/3/env/$if
+	Path string
+
+	// File name, line and column of the node if the template is parsed from the file
+	File   string
+	Line   int
+	Column int
+
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	var buf strings.Builder
+	if e.File != "" {
+		buf.WriteString(e.File)
+	}
+	if e.Line > 0 {
+		if buf.Len() > 0 {
+			buf.WriteString(":")
+		}
+		buf.WriteString(strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column))
+	}
+	if buf.Len() > 0 {
+		buf.WriteString(": ")
+	}
+	if e.Path != "" {
+		buf.WriteString(e.Path + ": ")
+	}
+	buf.WriteString(e.Err.Error())
+	return buf.String()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateErrors contains all errors of the template collected by the parser with the WithAllErrors option
+type TemplateErrors struct {
+	Errors []error
+}
+
+func (e *TemplateErrors) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	var buf strings.Builder
+	buf.WriteString(strconv.Itoa(len(e.Errors)) + " template errors:")
+	for _, err := range e.Errors {
+		buf.WriteString("\n  " + strings.ReplaceAll(err.Error(), "\n", "\n    "))
+	}
+	return buf.String()
+}
+
+// Unwrap returns the list of errors, so errors.Is and errors.As check every error of the list
+func (e *TemplateErrors) Unwrap() []error {
+	return e.Errors
+}
+
+// CanceledError is returned if the template processing is canceled or its deadline is exceeded.
+// It wraps the context error, so errors.Is(err, context.DeadlineExceeded) works as expected.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "template processing canceled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ctxCanceled returns *CanceledError if the processing context is done
+func ctxCanceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &CanceledError{Err: err}
+	}
+	return nil
+}
+
+// parseError wraps the error of the template node.
+// If all errors are collected then the error is recorded and nil is returned,
+// so the parser continues with the rest of the template.
+func parseError(ctx context.Context, err error) error {
+	if err = newTemplateError(ctx, err); err == nil {
+		return nil
+	}
+	if errs, _ := ctx.Value(ctxParseErrorsKey).(*TemplateErrors); errs != nil {
+		errs.Errors = append(errs.Errors, err)
+		return nil
+	}
+	return err
+}
+
+// sourcePosition is the position of the node in the source file
+type sourcePosition struct {
+	line   int
+	column int
+}
+
+// sourceMap contains positions of template nodes by their paths
+type sourceMap struct {
+	file      string
+	positions map[string]sourcePosition
+}
+
+// lookup returns the position of the node or of the nearest parent node
+func (m *sourceMap) lookup(path string) sourcePosition {
+	if m == nil {
+		return sourcePosition{}
+	}
+	for {
+		if pos, ok := m.positions[path]; ok {
+			return pos
+		}
+		idx := strings.LastIndex(path, "/")
+		if idx < 0 {
+			return sourcePosition{}
+		}
+		path = path[:idx]
+	}
+}
+
+func (m *sourceMap) set(path string, pos sourcePosition) {
+	if m.positions == nil {
+		m.positions = map[string]sourcePosition{}
+	}
+	m.positions[path] = pos
+}
+
+// joinPath appends the segment to the JSON pointer path
+func joinPath(path string, segment any) string {
+	switch s := segment.(type) {
+	case int:
+		return path + "/" + strconv.Itoa(s)
+	case string:
+		return path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+	}
+	return path
+}
+
+func ctxWithPath(ctx context.Context, segments ...any) context.Context {
+	path := ctxPath(ctx)
+	for _, segment := range segments {
+		path = joinPath(path, segment)
+	}
+	return context.WithValue(ctx, ctxPathKey, path)
+}
+
+// ctxPath returns the path of the currently parsed template node
+func ctxPath(ctx context.Context) string {
+	path, _ := ctx.Value(ctxPathKey).(string)
+	return path
+}
+
+func ctxWithSourceMap(ctx context.Context, src *sourceMap) context.Context {
+	return context.WithValue(ctx, ctxSourceMapKey, src)
+}
+
+func ctxSourceMap(ctx context.Context) *sourceMap {
+	src, _ := ctx.Value(ctxSourceMapKey).(*sourceMap)
+	return src
+}
+
+// newTemplateError wraps the error with the location of the current template node,
+// the error which already has the location is returned as is
+func newTemplateError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var tplErr *TemplateError
+	if errors.As(err, &tplErr) {
+		return err
+	}
+	return ctxBlockSource(ctx).wrapError(err)
+}
+
+// blockSource keeps the location of the block in the template
+// to wrap errors of the processing
+type blockSource struct {
+	src *TemplateError
+}
+
+// ctxBlockSource returns location of the current template node
+func ctxBlockSource(ctx context.Context) blockSource {
+	path := ctxPath(ctx)
+	srcMap := ctxSourceMap(ctx)
+	pos := srcMap.lookup(path)
+	src := &TemplateError{Path: path, Line: pos.line, Column: pos.column}
+	if srcMap != nil {
+		src.File = srcMap.file
+	}
+	return blockSource{src: src}
+}
+
+func (s *blockSource) setSource(src blockSource) {
+	if s.src == nil {
+		*s = src
+	}
+}
+
+// wrapError wraps the error with the block location if it has no location yet
+func (s blockSource) wrapError(err error) error {
+	if err == nil || s.src == nil {
+		return err
+	}
+	var tplErr *TemplateError
+	if errors.As(err, &tplErr) {
+		return err
+	}
+	nErr := *s.src
+	nErr.Err = err
+	return &nErr
+}
+
+type blockSourceSetter interface {
+	setSource(src blockSource)
+}
+
+// setBlockSource sets the location of the current template node to the block
+func setBlockSource(ctx context.Context, block any) {
+	if bl, ok := block.(blockSourceSetter); ok {
+		bl.setSource(ctxBlockSource(ctx))
+	}
+}
@@ -0,0 +1,178 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateErrorParse(t *testing.T) {
+	tests := []struct {
+		name string
+		tpl  any
+		path string
+	}{
+		{
+			name: "expr",
+			tpl:  map[string]any{"services": []any{"ok", map[string]any{"name": "{{name +}}"}}},
+			path: "/services/1/name",
+		},
+		{
+			name: "if",
+			tpl:  map[string]any{"env": map[string]any{"$if": "a +", "v": 1}},
+			path: "/env/$if",
+		},
+		{
+			name: "if-body",
+			tpl:  map[string]any{"env": map[string]any{"$if": "a", "v": "{{a +}}"}},
+			path: "/env/v",
+		},
+		{
+			name: "elif",
+			tpl: map[string]any{"$if": "a", "v": 1, "$elif": []any{
+				map[string]any{"$cond": "b", "v": 2},
+				map[string]any{"$cond": "c +", "v": 3},
+			}},
+			path: "/$elif/1",
+		},
+		{
+			name: "iterate-where",
+			tpl:  map[string]any{"list": map[string]any{"$iterate": "items", "$where": "item +", "$body": "{{item}}"}},
+			path: "/list/$where",
+		},
+		{
+			name: "iterate-body",
+			tpl:  map[string]any{"list": map[string]any{"$iterate": map[string]any{"$expr": "items", "$body": "{{item +}}"}}},
+			path: "/list/$iterate/$body",
+		},
+		{
+			name: "switch-case",
+			tpl:  map[string]any{"$switch": "env", "$case": []any{map[string]any{"$cond": "value +", "$body": 1}}},
+			path: "/$case/0/$cond",
+		},
+		{
+			name: "path-escape",
+			tpl:  map[string]any{"a/b~c": "{{a +}}"},
+			path: "/a~1b~0c",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTemplateFor(test.tpl)
+			var tplErr *TemplateError
+			if assert.True(t, errors.As(err, &tplErr), err) {
+				assert.Equal(t, test.path, tplErr.Path)
+				assert.Zero(t, tplErr.Line)
+			}
+		})
+	}
+}
+
+func TestTemplateErrorPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		source string
+		path   string
+		line   int
+		column int
+	}{
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			source: "services:\n  - name: web\n    env:\n      $if: \"a +\"\n      debug: true\n",
+			path:   "/services/0/env/$if",
+			line:   4,
+			column: 12,
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			source: "{\n  \"services\": [\n    {\"name\": \"{{name +}}\"}\n  ]\n}",
+			path:   "/services/0/name",
+			line:   3,
+			column: 14,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseReader(strings.NewReader(test.source), test.format)
+			var tplErr *TemplateError
+			if assert.True(t, errors.As(err, &tplErr), err) {
+				assert.Equal(t, test.path, tplErr.Path)
+				assert.Equal(t, test.line, tplErr.Line)
+				assert.Equal(t, test.column, tplErr.Column)
+			}
+		})
+	}
+}
+
+func TestTemplateErrorRuntime(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader("items:\n  $iterate: items\n  $body:\n    name: \"{{item.name}}\"\n    port: \"{{item.port / 0 + item.name}}\"\n"), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tpl.Process(context.Background(), map[string]any{
+		"items": []any{map[string]any{"name": "web", "port": 80}},
+	})
+	var tplErr *TemplateError
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "/items/$body/port", tplErr.Path)
+		assert.Equal(t, 5, tplErr.Line)
+		assert.True(t, strings.HasPrefix(err.Error(), "5:11: /items/$body/port: "), err.Error())
+	}
+
+	_, err = tpl.Process(context.Background(), map[string]any{"items": 1})
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "/items/$iterate", tplErr.Path)
+		assert.ErrorIs(t, err, errInvalidIteratator)
+	}
+}
+
+func TestTemplateErrorsCollect(t *testing.T) {
+	tpl := NewOrderedMap().
+		Set("name", "{{name +}}").
+		Set("with", NewOrderedMap().Set("$with", "name").Set("$body", "{{name -}}")).
+		Set("list", NewOrderedMap().
+			Set("$iterate", "items").
+			Set("$where", "item +").
+			Set("$limit", -1).
+			Set("$body", "{{item}}").
+			Set("extra", 1)).
+		Set("ok", "{{name}}")
+
+	_, err := NewTemplateFor(tpl)
+	var tplErr *TemplateError
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "/name", tplErr.Path, "the first error only")
+	}
+
+	_, err = NewTemplateFor(tpl, WithAllErrors())
+	var tplErrs *TemplateErrors
+	if !assert.True(t, errors.As(err, &tplErrs), err) {
+		return
+	}
+	var paths []string
+	for _, err := range tplErrs.Errors {
+		if assert.True(t, errors.As(err, &tplErr), err) {
+			paths = append(paths, tplErr.Path)
+		}
+	}
+	assert.Equal(t, []string{
+		"/name",
+		"/with/$body",
+		"/with/$with",
+		"/list/$where",
+		"/list/$limit",
+		"/list/$iterate",
+	}, paths)
+	assert.ErrorIs(t, err, errInvalidWithBlockExpr)
+	assert.ErrorIs(t, err, errDataFieldsIsNotAllowedIfBodyIsDefined)
+	assert.True(t, strings.HasPrefix(err.Error(), "6 template errors:\n  /name: "), err.Error())
+
+	_, err = NewTemplateFor(map[string]any{"name": "{{name}}"}, WithAllErrors())
+	assert.NoError(t, err)
+}
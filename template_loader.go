@@ -0,0 +1,232 @@
+package datatemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errUnsupportedFormat = errors.New("unsupported format")
+	errInvalidSource     = errors.New("invalid template source")
+)
+
+// Format of the template source or output data
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatByFileName returns the format according to the file extension
+func FormatByFileName(name string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	}
+	return "", errors.Wrap(errUnsupportedFormat, name)
+}
+
+// ParseReader decodes the template source of the format and creates new template.
+// JSON and YAML sources keep the key order, TOML tables are processed in the sorted order.
+func ParseReader(r io.Reader, format Format, opts ...Option) (*Template, error) {
+	return parseNamedReader("", r, format, opts...)
+}
+
+// ParseFile reads the template from the JSON, YAML or TOML file
+func ParseFile(filename string, opts ...Option) (*Template, error) {
+	format, err := FormatByFileName(filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseNamedReader(filename, file, format, opts...)
+}
+
+// ParseFS parses all files of the file system which match the pattern
+// and returns templates by file names
+func ParseFS(fsys fs.FS, pattern string, opts ...Option) (map[string]*Template, error) {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[string]*Template, len(names))
+	for _, name := range names {
+		tpl, err := parseFSFile(fsys, name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = tpl
+	}
+	return templates, nil
+}
+
+func parseFSFile(fsys fs.FS, name string, opts ...Option) (*Template, error) {
+	format, err := FormatByFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseNamedReader(name, file, format, opts...)
+}
+
+// parseNamedReader parses the template source with the file name,
+// which is used together with node positions in template errors
+func parseNamedReader(name string, r io.Reader, format Format, opts ...Option) (*Template, error) {
+	src := &sourceMap{file: name}
+	data, err := decodeSource(r, format, src)
+	if err != nil {
+		if name != "" {
+			return nil, errors.Wrap(err, name)
+		}
+		return nil, err
+	}
+	return NewTemplateFor(data, append(opts[:len(opts):len(opts)], withSourceMap(src))...)
+}
+
+// decodeSource decodes the template source data of the format,
+// positions of JSON nodes are recorded into the source map
+// and positions of YAML nodes are recorded by the parser.
+// TOML decoder provides no positions of nodes.
+func decodeSource(r io.Reader, format Format, src *sourceMap) (any, error) {
+	switch format {
+	case FormatJSON, FormatJSONPretty:
+		source, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(source))
+		dec.UseNumber()
+		data, err := (&jsonDecoder{dec: dec, source: source, srcMap: src}).decode("")
+		if err != nil {
+			return nil, err
+		}
+		// The source must contain the single JSON value
+		if _, err = dec.Token(); err != io.EOF {
+			return nil, errors.Wrapf(errInvalidSource, "unexpected data after the JSON value at offset %d", dec.InputOffset())
+		}
+		return data, nil
+	case FormatYAML:
+		var node yaml.Node
+		if err := yaml.NewDecoder(r).Decode(&node); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return &node, nil
+	case FormatTOML:
+		var data map[string]any
+		if _, err := toml.NewDecoder(r).Decode(&data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, errors.Wrap(errUnsupportedFormat, string(format))
+}
+
+// decodeJSON decodes the next JSON value with preserving of the key order
+func decodeJSON(dec *json.Decoder) (any, error) {
+	return (&jsonDecoder{dec: dec}).decode("")
+}
+
+// jsonDecoder decodes JSON values with preserving of the key order
+// and records positions of values if the source map is defined
+type jsonDecoder struct {
+	dec    *json.Decoder
+	source []byte
+	srcMap *sourceMap
+
+	// Scanned offset of the source, the number of lines and the start of the last line before it
+	offset    int
+	line      int
+	lineStart int
+}
+
+func (d *jsonDecoder) decode(path string) (any, error) {
+	d.record(path)
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeValue(path, tok)
+}
+
+func (d *jsonDecoder) decodeValue(path string, tok json.Token) (any, error) {
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			m := NewOrderedMap()
+			for d.dec.More() {
+				keyTok, err := d.dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := d.decode(joinPath(path, keyTok.(string)))
+				if err != nil {
+					return nil, err
+				}
+				m.Set(keyTok.(string), val)
+			}
+			_, err := d.dec.Token()
+			return m, err
+		case '[':
+			list := []any{}
+			for d.dec.More() {
+				val, err := d.decode(joinPath(path, len(list)))
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, val)
+			}
+			_, err := d.dec.Token()
+			return list, err
+		}
+		return nil, errors.Errorf("unexpected JSON delimiter %s", v)
+	case json.Number:
+		if num, err := v.Int64(); err == nil {
+			return int(num), nil
+		}
+		return v.Float64()
+	}
+	return tok, nil
+}
+
+// record the position of the next value as the position of the path
+func (d *jsonDecoder) record(path string) {
+	if d.srcMap == nil {
+		return
+	}
+	// The decoder offset points to the end of the previous token
+	offset := int(d.dec.InputOffset())
+	for offset < len(d.source) && strings.IndexByte(" \t\r\n:,", d.source[offset]) >= 0 {
+		offset++
+	}
+	for ; d.offset < offset; d.offset++ {
+		if d.source[d.offset] == '\n' {
+			d.line++
+			d.lineStart = d.offset + 1
+		}
+	}
+	d.srcMap.set(path, sourcePosition{line: d.line + 1, column: offset - d.lineStart + 1})
+}
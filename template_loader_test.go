@@ -0,0 +1,90 @@
+package datatemplate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"tpl/service.yaml": {Data: []byte("name: \"{{name}}\"\nports:\n  $iterate: ports\n  $body: \"{{item}}\"\n")},
+		"tpl/service.json": {Data: []byte(`{"name": "{{name}}", "ports": {"$iterate": "ports", "$body": "{{item}}"}}`)},
+		"tpl/service.toml": {Data: []byte("name = \"{{name}}\"\n[ports]\n\"$iterate\" = \"ports\"\n\"$body\" = \"{{item}}\"\n")},
+		"tpl/broken.yml":   {Data: []byte("name: \"{{name +}}\"\n")},
+	}
+	data := map[string]any{"name": "web", "ports": []any{80, 443}}
+
+	templates, err := ParseFS(fsys, "tpl/service.*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, templates, 3)
+	for name, tpl := range templates {
+		res, err := tpl.Process(context.Background(), data)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, "web", toOrderedMap(res).Get("name"), name)
+		assert.Equal(t, []any{80, 443}, toOrderedMap(res).Get("ports"), name)
+	}
+
+	_, err = ParseFS(fsys, "tpl/*.yml")
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "tpl/broken.yml:1:7: /name: "), err.Error())
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if !assert.NoError(t, os.WriteFile(filename, []byte(`{"b": "{{name}}", "a": 1.5}`), 0o600)) {
+		return
+	}
+	tpl, err := ParseFile(filename)
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"name": "web"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"b", "a"}, res.(*OrderedMap).Keys())
+		assert.Equal(t, map[string]any{"b": "web", "a": 1.5}, res.(*OrderedMap).Map())
+	}
+
+	_, err = ParseFile("config.ini")
+	assert.ErrorIs(t, err, errUnsupportedFormat)
+}
+
+func TestParseEmptySource(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		format Format
+	}{
+		{source: "", format: FormatYAML},
+		{source: "# no data\n", format: FormatYAML},
+		{source: "null", format: FormatJSON},
+	} {
+		tpl, err := ParseReader(strings.NewReader(tc.source), tc.format)
+		if !assert.NoError(t, err, tc.source) {
+			continue
+		}
+		res, err := tpl.Process(context.Background(), map[string]any{"name": "web"})
+		assert.NoError(t, err, tc.source)
+		assert.Nil(t, res, tc.source)
+		assert.Empty(t, tpl.Variables(), tc.source)
+		assert.NotPanics(t, func() { _ = tpl.String() }, tc.source)
+	}
+}
+
+func TestParseJSONTrailingData(t *testing.T) {
+	_, err := ParseReader(strings.NewReader(`{"a": 1} garbage`), FormatJSON)
+	assert.ErrorIs(t, err, errInvalidSource)
+	_, err = ParseReader(strings.NewReader(`{"a": 1} {"b": 2}`), FormatJSON)
+	assert.ErrorIs(t, err, errInvalidSource)
+	_, err = ParseReader(strings.NewReader("{\"a\": 1}\n"), FormatJSON)
+	assert.NoError(t, err)
+}
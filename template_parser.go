@@ -2,12 +2,16 @@ package datatemplate
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/parser"
 	"github.com/demdxx/gocast/v2"
-	"github.com/demdxx/xtypes"
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -15,62 +19,281 @@ var (
 	errInvalidIteratorBlock                  = errors.New("invalid iterator block")
 	errInvalidWithBlock                      = errors.New("invalid with block")
 	errInvalidWithBlockExpr                  = errors.New("invalid with block expr")
+	errInvalidSwitchBlock                    = errors.New("invalid switch block")
+	errInvalidSwitchCase                     = errors.New("invalid switch case")
+	errInvalidIncludeBlock                   = errors.New("invalid include block")
+	errInvalidDefineBlock                    = errors.New("invalid define block")
+	errInvalidCallBlock                      = errors.New("invalid call block")
+	errUnknownMacro                          = errors.New("unknown macro")
 	errDataFieldsIsNotAllowedIfBodyIsDefined = errors.New("data fields is not allowed if body is defined")
+	errUnknownDirective                      = errors.New("unknown directive")
+	errMisplacedDirective                    = errors.New("misplaced directive")
 )
 
+// List of all keys which are processed by the parser
+var directiveKeys = append([]string{
+	"$if", "$elif", "$else", "$cond", "$condition",
+	"$iterate", "$body",
+	"$with",
+	"$switch", "$case", "$default",
+	"$spread", "$...",
+	"$define", "$args",
+}, iterateOptionKeys...)
+
 func parseBlocks(ctx context.Context, data any) (any, error) {
+	switch node := data.(type) {
+	case *yaml.Node:
+		return parseYAMLNode(ctx, node)
+	case yaml.Node:
+		return parseYAMLNode(ctx, &node)
+	}
+
 	switch {
 	case gocast.IsSlice(data):
 		arr := gocast.AnySlice[any](data)
 		blocks := make([]any, 0, len(arr))
 		hasBlocks := false
-		for _, item := range arr {
-			block, err := parseBlocks(ctx, item)
-			if err != nil {
+		for i, item := range arr {
+			itemCtx := ctxWithPath(ctx, i)
+			block, err := parseBlocks(itemCtx, item)
+			if err = parseError(itemCtx, err); err != nil {
 				return nil, err
 			}
+			// The list item with the only spread key is spliced into the list,
+			// while the map under the key keeps the spread inside of it
+			if spread := onlySpread(block); spread != nil {
+				block = spread
+			}
 			if _, ok := block.(Block); ok {
 				hasBlocks = true
 			}
 			blocks = append(blocks, block)
 		}
 		if hasBlocks {
-			return &DataBlockSlice{data: blocks}, nil
+			block := &DataBlockSlice{data: blocks}
+			setBlockSource(ctx, block)
+			return block, nil
 		}
-	case gocast.IsMap(data) || gocast.IsStruct(data):
-		m := gocast.Map[string, any](data)
+	case isMapData(data):
+		m := toOrderedMap(data)
 
-		if _, ok := m["$if"]; ok {
-			return parseIfBlock(ctx, m)
-		}
-		if _, ok := m["$iterate"]; ok {
-			return parseIteratorBlock(ctx, m)
+		// Macros are defined only at the root of the template
+		if _, ok := m.Lookup("$define"); ok && ctxPath(ctx) == "" {
+			return parseDefineBlock(ctx, m)
 		}
-		if _, ok := m["$with"]; ok {
-			return parseWithBlock(ctx, m)
+
+		for _, directive := range ctxDirectives(ctx) {
+			if _, ok := m.Lookup(directive.Name()); ok {
+				return parseDirective(ctx, directive, m)
+			}
 		}
 
-		blocks := make(map[string]any, len(m))
+		blocks := make(map[string]any, m.Len())
+		keys := make([]string, 0, m.Len())
 		hasBlocks := false
-		for key, item := range m {
-			block, err := parseBlocks(ctx, item)
-			if err != nil {
+		if err := checkDirectiveKeys(ctx, m); err != nil {
+			return nil, err
+		}
+		for _, key := range m.keys {
+			keyCtx := ctxWithPath(ctx, key)
+			block, err := parseBlocks(keyCtx, m.values[key])
+			if err = parseError(keyCtx, err); err != nil {
 				return nil, err
 			}
+			if isSpreadKey(key) {
+				if block == nil {
+					continue
+				}
+				spread := NewSpreadBlock(NewDataBlock(block))
+				setBlockSource(keyCtx, spread)
+				block = spread
+			}
 			if _, ok := block.(Block); ok {
 				hasBlocks = true
 			}
 			blocks[key] = block
+			keys = append(keys, key)
 		}
 		if hasBlocks {
-			return &DataBlockMap{data: blocks}, nil
+			block := &DataBlockMap{data: blocks, order: keys, ordered: !m.sorted}
+			setBlockSource(ctx, block)
+			return block, nil
+		}
+		// The map was built from the unordered map by the parser itself
+		if om, ok := data.(*OrderedMap); ok && om.sorted {
+			return om.Map(), nil
 		}
 	case gocast.IsStr(data):
-		return NewExprBlockFromString(ctx, gocast.Str(data))
+		block, err := NewExprBlockFromString(ctx, gocast.Str(data))
+		if err != nil {
+			return nil, newTemplateError(ctx, err)
+		}
+		setBlockSource(ctx, block)
+		return block, nil
 	}
 	return data, nil
 }
 
+// onlySpread returns the spread block of the map which has no other keys
+func onlySpread(block any) *SpreadBlock {
+	mp, ok := block.(*DataBlockMap)
+	if !ok || len(mp.data) != 1 {
+		return nil
+	}
+	for _, item := range mp.data {
+		spread, _ := item.(*SpreadBlock)
+		return spread
+	}
+	return nil
+}
+
+// checkDirectiveKeys checks `$` keys of the data map in the strict mode.
+// Unknown keys are reported first, as the misplaced key like `$body`
+// is usually caused by the misspelled directive of the same map.
+func checkDirectiveKeys(ctx context.Context, data *OrderedMap) error {
+	if ctxGetOptions(ctx).lax {
+		return nil
+	}
+	for _, unknown := range []bool{true, false} {
+		for _, key := range data.keys {
+			err := checkDirectiveKey(ctx, key)
+			if err == nil || errors.Is(err, errUnknownDirective) != unknown {
+				continue
+			}
+			if err = parseError(ctxWithPath(ctx, key), err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkBodyFields returns the error at the path of fieldsCtx if the map with `$body` has other fields
+// except allowed ones, the misspelled directive is reported as unknown in the strict mode
+func checkBodyFields(ctx, fieldsCtx context.Context, data *OrderedMap, allowed ...string) error {
+	for _, key := range data.keys {
+		if key == "$body" || hasString(allowed, key) {
+			continue
+		}
+		if err := checkDirectiveKey(ctx, key); !ctxGetOptions(ctx).lax && errors.Is(err, errUnknownDirective) {
+			return parseError(ctxWithPath(ctx, key), err)
+		}
+		return parseError(fieldsCtx, errDataFieldsIsNotAllowedIfBodyIsDefined)
+	}
+	return nil
+}
+
+// checkDirectiveKey returns the error if the key of the data map
+// looks like the directive which can't be processed here
+func checkDirectiveKey(ctx context.Context, key string) error {
+	if !strings.HasPrefix(key, "$") || isSpreadKey(key) {
+		return nil
+	}
+	switch key {
+	case "$elif", "$else":
+		return fmt.Errorf("%w %s without $if", errMisplacedDirective, key)
+	case "$case", "$default":
+		return fmt.Errorf("%w %s without $switch", errMisplacedDirective, key)
+	}
+	if hasString(directiveKeys, key) {
+		return fmt.Errorf("%w %s", errMisplacedDirective, key)
+	}
+	if suggestion := suggestDirective(ctx, key); suggestion != "" {
+		return fmt.Errorf("%w %s, did you mean %s?", errUnknownDirective, key, suggestion)
+	}
+	return fmt.Errorf("%w %s", errUnknownDirective, key)
+}
+
+// suggestDirective returns the closest known directive key for the misspelled one
+func suggestDirective(ctx context.Context, key string) string {
+	var (
+		suggestion string
+		// The number of allowed typos grows with the key length
+		best = (len(key)-1)/3 + 1
+	)
+	if best < 2 {
+		best = 2
+	}
+	names := directiveKeys
+	for _, directive := range ctxDirectives(ctx) {
+		names = append(names[:len(names):len(names)], directive.Name())
+	}
+	for _, name := range names {
+		if dist := editDistance(strings.ToLower(key), strings.ToLower(name)); dist < best {
+			suggestion, best = name, dist
+		}
+	}
+	return suggestion
+}
+
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if v := prev[j] + 1; v < curr[j] {
+				curr[j] = v
+			}
+			if v := curr[j-1] + 1; v < curr[j] {
+				curr[j] = v
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// parseDirective parses the map with the directive key,
+// the block and its errors are bound to the path of the directive key
+func parseDirective(ctx context.Context, directive Directive, data *OrderedMap) (Block, error) {
+	dctx := ctxWithPath(ctx, directive.Name())
+	block, err := directive.Parse(ctx, data)
+	if err != nil {
+		return nil, newTemplateError(dctx, err)
+	}
+	if _, ok := block.(blockSourceSetter); !ok && block != nil {
+		if d, _ := directive.(*directiveFunc); d == nil || !d.builtin {
+			block = &directiveBlock{Block: block}
+		}
+	}
+	setBlockSource(dctx, block)
+	return block, nil
+}
+
+// parseYAMLNode parses YAML document with preserving of the key order,
+// positions of nodes are recorded for template errors
+func parseYAMLNode(ctx context.Context, node *yaml.Node) (any, error) {
+	src := ctxSourceMap(ctx)
+	if src == nil {
+		src = &sourceMap{}
+		ctx = ctxWithSourceMap(ctx, src)
+	}
+	data, err := fromYAMLNodeAt(node, ctxPath(ctx), src)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlocks(ctx, data)
+}
+
 // Example 1:
 // $if: "person.age > 18"
 // field1: "value1"
@@ -87,12 +310,30 @@ func parseBlocks(ctx context.Context, data any) (any, error) {
 //
 //	field1: "value1"
 //	field2: "value2"
-func parseIfBlock(ctx context.Context, data map[string]any) (Block, error) {
+//
+// Example 3:
+// $if:
+//
+//	$cond: "env == 'prod'"
+//	replicas: 3
+//
+// $elif:
+//
+//   - $cond: "env == 'stage'"
+//     replicas: 2
+//   - $cond: "env == 'dev'"
+//     replicas: 1
+//
+// $else:
+//
+//	replicas: 0
+func parseIfBlock(ctx context.Context, data *OrderedMap) (Block, error) {
 	var (
-		ifdata, ok = data["$if"]
+		ifdata, ok = data.Lookup("$if")
 		condition  string
 		thenBlock  Block
 		elseBlock  Block
+		err        error
 	)
 	if !ok {
 		return nil, errInvalidIfBlock
@@ -100,35 +341,113 @@ func parseIfBlock(ctx context.Context, data map[string]any) (Block, error) {
 
 	if gocast.IsStr(ifdata) {
 		condition = gocast.Str(ifdata)
-		dataCopy := xtypes.Map[string, any](data).Filter(func(k string, _ any) bool { return k != "$if" })
-		body, err := parseBlocks(ctx, dataCopy)
-		if err != nil {
+		body, err := parseBlocks(ctx, data.Copy().Delete("$if", "$elif", "$else"))
+		if err = parseError(ctx, err); err != nil {
 			return nil, err
 		}
 		thenBlock = NewDataBlock(body)
 	} else {
-		condData := xtypes.Map[string, any](gocast.Map[string, any](ifdata)).Copy()
-		condition = gocast.Str(condData["$cond"])
-		if condition == "" {
-			condition = gocast.Str(condData["$condition"])
-		}
-		delete(condData, "$cond")
-		delete(condData, "$condition")
-		body, err := parseBlocks(ctx, condData)
-		if err != nil {
+		if condition, thenBlock, err = parseCondBlock(ctxWithPath(ctx, "$if"), ifdata); err != nil {
 			return nil, err
 		}
-		thenBlock = NewDataBlock(body)
-		body, err = parseBlocks(ctx, data["$else"])
-		if err != nil {
+	}
+
+	if elseData, ok := data.Lookup("$else"); ok {
+		elseCtx := ctxWithPath(ctx, "$else")
+		body, err := parseBlocks(elseCtx, elseData)
+		if err = parseError(elseCtx, err); err != nil {
 			return nil, err
 		}
 		elseBlock = NewDataBlock(body)
 	}
 
+	if elifData, ok := data.Lookup("$elif"); ok {
+		if elseBlock, err = parseElifBlocks(ctxWithPath(ctx, "$elif"), elifData, elseBlock); err != nil {
+			return nil, err
+		}
+	}
+
 	return NewIfBlockWithContition(ctx, condition, thenBlock, elseBlock)
 }
 
+// parseElifBlocks compiles the list of `$elif` branches into the chain of if blocks
+// where every next branch is the else block of the previous one
+func parseElifBlocks(ctx context.Context, data any, elseBlock Block) (Block, error) {
+	var list []any
+	switch {
+	case gocast.IsSlice(data):
+		list = gocast.AnySlice[any](data)
+	case isMapData(data):
+		list = []any{data}
+	default:
+		return nil, newTemplateError(ctx, errors.Wrap(errInvalidIfBlock, "$elif must be a list of conditions"))
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		itemCtx := ctx
+		if gocast.IsSlice(data) {
+			itemCtx = ctxWithPath(ctx, i)
+		}
+		condition, thenBlock, err := parseCondBlock(itemCtx, list[i])
+		if err != nil {
+			if err = parseError(itemCtx, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block, err := NewIfBlockWithContition(itemCtx, condition, thenBlock, elseBlock)
+		if err != nil {
+			if err = parseError(itemCtx, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		elseBlock = block
+		setBlockSource(itemCtx, elseBlock)
+	}
+	return elseBlock, nil
+}
+
+// parseCondBlock parses the map with `$cond` field and body fields or `$body`
+func parseCondBlock(ctx context.Context, data any) (string, Block, error) {
+	if !isMapData(data) {
+		return "", nil, errors.Wrap(errInvalidIfBlock, "condition block must be a map")
+	}
+	condData := toOrderedMap(data).Copy()
+	condition := gocast.Str(condData.Get("$cond"))
+	if condition == "" {
+		condition = gocast.Str(condData.Get("$condition"))
+	}
+	var (
+		bodyData any = condData.Delete("$cond", "$condition")
+		bodyCtx      = ctx
+	)
+	// If body is defined then we should not have any other fields
+	if body, ok := condData.Lookup("$body"); ok {
+		if err := checkBodyFields(ctx, ctx, condData); err != nil {
+			return "", nil, err
+		}
+		bodyData, bodyCtx = body, ctxWithPath(ctx, "$body")
+	}
+	body, err := parseBlocks(bodyCtx, bodyData)
+	if err = parseError(bodyCtx, err); err != nil {
+		return "", nil, err
+	}
+	if condition == "" {
+		return "", nil, errors.Wrap(errInvalidIfBlock, "empty condition")
+	}
+	return condition, NewDataBlock(body), nil
+}
+
+// List of the iterate block parameters which are not part of the body
+var iterateOptionKeys = []string{
+	"$expr", "$index", "$key", "$value",
+	"$where", "$sortBy", "$limit", "$offset",
+	"$as", "$outKey", "$onDuplicate",
+}
+
+// Extract iterator variable names from expression like: value, index, key := expr
+var reIterateVariableNames = regexp.MustCompile(`^\s*([a-zA-Z0-9_]+)(?:\s*,\s*([a-zA-Z0-9_]+))?(?:\s*,\s*([a-zA-Z0-9_]+))?\s*:=\s*`)
+
 // Example 1:
 // $iterate: "data.list"
 // field1: "{{item.field1}}"
@@ -152,52 +471,234 @@ func parseIfBlock(ctx context.Context, data map[string]any) (Block, error) {
 // Example 4:
 // $iterate: "data.list"
 // $body: "{{index}}"
-func parseIteratorBlock(ctx context.Context, data map[string]any) (Block, error) {
+//
+// Example 5:
+// $iterate: "data.list"
+// $value: "node"
+// $index: "i"
+// $key: "k"
+// $body: "{{i}}: {{node.name}}"
+//
+// Example 6:
+// $iterate: "node, i := data.list"
+// $body: "{{i}}: {{node.name}}"
+func parseIteratorBlock(ctx context.Context, data *OrderedMap) (Block, error) {
 	var (
-		iterateData, ok = data["$iterate"]
-		iterateExpr     string
+		iterateData, ok = data.Lookup("$iterate")
+		params          *OrderedMap
+		paramsCtx       = ctx
 		bodyData        any
 	)
 	if !ok {
 		return nil, errInvalidIteratorBlock
 	}
 
-	if gocast.IsStr(iterateData) {
-		iterateExpr = gocast.Str(iterateData)
+	switch {
+	case gocast.IsStr(iterateData):
+		params = data.Copy().Delete("$iterate").Set("$expr", iterateData)
+	case isMapData(iterateData):
+		params = toOrderedMap(iterateData).Copy()
+		paramsCtx = ctxWithPath(ctx, "$iterate")
+	default:
+		return nil, errInvalidIteratorBlock
+	}
 
-		// If body is defined then we should not have any other fields
-		if bodyData, ok = data["$body"]; ok {
-			if len(data) > 2 {
-				return nil, errDataFieldsIsNotAllowedIfBodyIsDefined
-			}
-		} else {
-			// Remove $iterate field if present
-			bodyData = xtypes.Map[string, any](data).Filter(func(k string, _ any) bool { return k != "$iterate" })
+	iterateExpr := gocast.Str(params.Get("$expr"))
+	indexName := gocast.Str(params.Get("$index"))
+	keyName := gocast.Str(params.Get("$key"))
+	valueName := gocast.Str(params.Get("$value"))
+
+	// Extract variable names from the shorthand expression
+	if varArr := reIterateVariableNames.FindStringSubmatch(iterateExpr); len(varArr) == 4 {
+		valueName = strOrDef(valueName, varArr[1])
+		indexName = strOrDef(indexName, varArr[2])
+		keyName = strOrDef(keyName, varArr[3])
+		iterateExpr = strings.TrimSpace(strings.Replace(iterateExpr, varArr[0], "", 1))
+	}
+
+	// Options and body see loop variables typed by items of the iterated value
+	paramsCtx = ctxWithSchemaVars(paramsCtx, schemaIterateVars(ctx, iterateExpr, valueName, indexName, keyName)...)
+	opts, err := parseIterateOptions(paramsCtx, params)
+	if err != nil {
+		return nil, err
+	}
+	if keyLess := ctxGetOptions(ctx).keyLess; keyLess != nil {
+		opts = append(opts, WithIterateKeyOrder(keyLess))
+	}
+	if !params.sorted {
+		opts = append(opts, withIterateOrderedOutput())
+	}
+	params.Delete(iterateOptionKeys...)
+
+	// If body is defined then we should not have any other fields
+	bodyCtx := paramsCtx
+	if bodyData, ok = params.Lookup("$body"); ok {
+		if err = checkBodyFields(paramsCtx, ctxWithPath(ctx, "$iterate"), params); err != nil {
+			return nil, err
 		}
+		bodyCtx = ctxWithPath(paramsCtx, "$body")
 	} else {
-		dataCopy := xtypes.Map[string, any](gocast.Map[string, any](iterateData)).Copy()
-		iterateExpr = gocast.Str(dataCopy["$expr"])
+		bodyData = params
+	}
 
-		// If body is defined then we should not have any other fields
-		if bodyData, ok = dataCopy["$body"]; ok {
-			if len(dataCopy) > 2 {
-				return nil, errDataFieldsIsNotAllowedIfBodyIsDefined
+	// Parse blocks from data
+	body, err := parseBlocks(bodyCtx, bodyData)
+	if err = parseError(bodyCtx, err); err != nil {
+		return nil, err
+	}
+
+	return NewIterateBlockFromExpr(ctx, iterateExpr, indexName, keyName, valueName, NewDataBlock(body), opts...)
+}
+
+// Extract sort order from expression like: item.weight desc
+var reSortOrder = regexp.MustCompile(`(?i)\s+(asc|desc)\s*$`)
+
+// Example:
+// $iterate: "backends"
+// $where: "item.active"
+// $sortBy: "item.weight desc"
+// $offset: 0
+// $limit: 3
+// $body: "{{item.host}}"
+//
+// The `$sortBy` also can be defined as a map:
+// $sortBy:
+//
+//	$expr: "item.weight"
+//	$order: "desc"
+func parseIterateOptions(ctx context.Context, params *OrderedMap) ([]IterateOption, error) {
+	var opts []IterateOption
+
+	if where := gocast.Str(params.Get("$where")); where != "" {
+		program, err := compileExpr(ctx, where)
+		if err != nil {
+			if err = parseError(ctxWithPath(ctx, "$where"), errors.Wrap(err, where)); err != nil {
+				return nil, err
 			}
 		} else {
-			delete(dataCopy, "$expr")
-			bodyData = dataCopy
+			opts = append(opts, WithIterateWhere(program))
 		}
 	}
 
-	// Parse blocks from data
-	body, err := parseBlocks(ctx, bodyData)
-	if err != nil {
+	if sortData, ok := params.Lookup("$sortBy"); ok {
+		var sortExpr, order string
+		if isMapData(sortData) {
+			sortMap := toOrderedMap(sortData)
+			sortExpr, order = gocast.Str(sortMap.Get("$expr")), gocast.Str(sortMap.Get("$order"))
+		} else {
+			sortExpr = gocast.Str(sortData)
+			if orderArr := reSortOrder.FindStringSubmatch(sortExpr); len(orderArr) == 2 {
+				order = orderArr[1]
+				sortExpr = strings.TrimSpace(sortExpr[:len(sortExpr)-len(orderArr[0])])
+			}
+		}
+		program, err := compileExpr(ctx, sortExpr)
+		switch {
+		case order != "" && !strings.EqualFold(order, "asc") && !strings.EqualFold(order, "desc"):
+			err = errors.Wrap(errInvalidIteratorBlock, "invalid sort order "+order)
+		case err != nil:
+			err = errors.Wrap(err, sortExpr)
+		default:
+			opts = append(opts, WithIterateSortBy(program, strings.EqualFold(order, "desc")))
+		}
+		if err = parseError(ctxWithPath(ctx, "$sortBy"), err); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range []struct {
+		name   string
+		fn     func(int) IterateOption
+		exprFn func(*Program) IterateOption
+	}{
+		{name: "$limit", fn: WithIterateLimit, exprFn: WithIterateLimitExpr},
+		{name: "$offset", fn: WithIterateOffset, exprFn: WithIterateOffsetExpr},
+	} {
+		val, ok := params.Lookup(opt.name)
+		if !ok {
+			continue
+		}
+		// The value can be computed from the data like `$limit: "{{count}}"`
+		if str, ok := val.(string); ok && reExprExtract.MatchString(str) {
+			expression := strings.TrimSpace(str)
+			if match := reExprExtract.FindStringSubmatch(expression); match[0] == expression {
+				expression = match[1]
+			}
+			program, err := compileExpr(ctx, expression)
+			if err = parseError(ctxWithPath(ctx, opt.name), errors.Wrap(err, expression)); err != nil {
+				return nil, err
+			}
+			if program != nil {
+				opts = append(opts, opt.exprFn(program))
+			}
+			continue
+		}
+		num, err := gocast.TryNumber[int](val)
+		if err != nil || num < 0 {
+			if err = parseError(ctxWithPath(ctx, opt.name), errors.Wrap(errInvalidIteratorBlock, "invalid "+opt.name+" value")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		opts = append(opts, opt.fn(num))
+	}
+
+	if opt, err := parseIterateMapOutput(ctx, params); err != nil {
 		return nil, err
+	} else if opt != nil {
+		opts = append(opts, opt)
 	}
+	return opts, nil
+}
 
-	return NewIterateBlockFromExpr(ctx, iterateExpr, "", "", "", NewDataBlock(body))
+// Example:
+// $iterate: "services"
+// $as: map
+// $outKey: "{{item.name}}"
+// $onDuplicate: last
+// $body:
+//
+//	image: "{{item.image}}"
+func parseIterateMapOutput(ctx context.Context, params *OrderedMap) (IterateOption, error) {
+	var (
+		outAs          = strings.ToLower(gocast.Str(params.Get("$as")))
+		keyData, isKey = params.Lookup("$outKey")
+		keyBlock       Block
+		policy         = DuplicateKeyError
+	)
+	switch {
+	case outAs == "" && !isKey, outAs == "list":
+		if isKey {
+			return nil, errors.Wrap(errInvalidIteratorBlock, "$outKey is not allowed for the list output")
+		}
+		return nil, nil
+	case outAs != "" && outAs != "map":
+		return nil, errors.Wrap(errInvalidIteratorBlock, "invalid $as value "+outAs)
+	}
+
+	if isKey {
+		key, err := parseBlocks(ctxWithPath(ctx, "$outKey"), keyData)
+		if err = parseError(ctxWithPath(ctx, "$outKey"), err); err != nil {
+			return nil, err
+		}
+		keyBlock = NewDataBlock(key)
+	}
+
+	switch onDuplicate := strings.ToLower(gocast.Str(params.Get("$onDuplicate"))); onDuplicate {
+	case "", "error":
+	case "first":
+		policy = DuplicateKeyFirst
+	case "last":
+		policy = DuplicateKeyLast
+	default:
+		return nil, errors.Wrap(errInvalidIteratorBlock, "invalid $onDuplicate value "+onDuplicate)
+	}
+	return WithIterateMapOutput(keyBlock, policy), nil
 }
 
+// Valid name of the variable or the macro
+var reVariableName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 // Extract variable name from expression like: varName := expr
 var reLeftVariableName = regexp.MustCompile(`^\s*([a-zA-Z0-9_]+)\s*:=\s*`)
 
@@ -227,54 +728,705 @@ var reLeftVariableName = regexp.MustCompile(`^\s*([a-zA-Z0-9_]+)\s*:=\s*`)
 // $with: varName := np.name
 // field1: "{{var}}"
 // field2: "{{np.age}}"
-func parseWithBlock(ctx context.Context, data map[string]any) (Block, error) {
+//
+// Example 5:
+// $with:
+//
+//	[host := service.host, port := service.port ?? 80, url := host + port]
+//
+// $body: "{{url}}"
+func parseWithBlock(ctx context.Context, data *OrderedMap) (Block, error) {
+	return parseScopeBlock(ctx, data, "$with")
+}
+
+// Example:
+// $let:
+//
+//	host: service.host
+//	port: service.port ?? 80
+//	addr: host + ":" + string(port)
+//
+// $body: "{{addr}}"
+//
+// Variables are evaluated in the order of the map keys, keys of the unordered map are sorted.
+func parseLetBlock(ctx context.Context, data *OrderedMap) (Block, error) {
+	return parseScopeBlock(ctx, data, "$let")
+}
+
+// parseScopeBlock parses the `$with` or `$let` block which binds variables into the new scope of the body
+func parseScopeBlock(ctx context.Context, data *OrderedMap, key string) (Block, error) {
 	var (
-		withData, ok = data["$with"]
-		withExpr     string
-		bodyData     any
+		scopeData, ok = data.Lookup(key)
+		varsData      any
+		varsCtx       = ctxWithPath(ctx, key)
+		bodyData      any
+		bodyCtx       = ctx
 	)
 	if !ok {
 		return nil, errInvalidWithBlock
 	}
 
-	if gocast.IsStr(withData) {
-		withExpr = gocast.Str(withData)
+	switch {
+	case key == "$let" || gocast.IsStr(scopeData) || gocast.IsSlice(scopeData):
+		varsData = scopeData
 
 		// If body is defined then we should not have any other fields
-		if bodyData, ok = data["$body"]; ok {
-			if len(data) > 2 {
-				return nil, errDataFieldsIsNotAllowedIfBodyIsDefined
+		if bodyData, ok = data.Lookup("$body"); ok {
+			if err := checkBodyFields(ctx, ctxWithPath(ctx, key), data, key); err != nil {
+				return nil, err
 			}
+			bodyCtx = ctxWithPath(ctx, "$body")
 		} else {
-			// Remove $with field if present
-			bodyData = xtypes.Map[string, any](data).Filter(func(k string, _ any) bool { return k != "$with" })
+			// Remove scope field if present
+			bodyData = data.Copy().Delete(key)
 		}
-	} else {
-		dataCopy := xtypes.Map[string, any](gocast.Map[string, any](withData)).Copy()
-		withExpr = gocast.Str(dataCopy["$expr"])
+	case isMapData(scopeData):
+		dataCopy := toOrderedMap(scopeData).Copy()
+		varsData = dataCopy.Get("$expr")
+		bodyCtx, varsCtx = varsCtx, ctxWithPath(varsCtx, "$expr")
 
 		// If body is defined then we should not have any other fields
-		if bodyData, ok = dataCopy["$body"]; ok {
-			if len(dataCopy) > 2 {
-				return nil, errDataFieldsIsNotAllowedIfBodyIsDefined
+		if bodyData, ok = dataCopy.Lookup("$body"); ok {
+			if err := checkBodyFields(bodyCtx, bodyCtx, dataCopy, "$expr"); err != nil {
+				return nil, err
 			}
+			bodyCtx = ctxWithPath(bodyCtx, "$body")
 		} else {
-			delete(dataCopy, "$expr")
-			bodyData = dataCopy
+			bodyData = dataCopy.Delete("$expr")
 		}
+	default:
+		return nil, errors.Wrap(errInvalidWithBlockExpr, "expression, list or map expected")
 	}
 
-	varArr := reLeftVariableName.FindStringSubmatch(withExpr)
-	if len(varArr) < 2 {
-		return nil, errors.Wrap(errInvalidWithBlockExpr, withExpr)
+	// The body sees variables typed by the schema, errors of variables are reported below
+	if ctxSchema(ctx) != nil {
+		if names, exprs, ctxs, err := splitScopeVars(varsCtx, varsData, key == "$let"); err == nil {
+			_, bodyCtx = ctxWithScopeVars(ctxs, names, exprs, bodyCtx)
+		}
 	}
-	withExpr = strings.TrimSpace(strings.Replace(withExpr, varArr[0], "", 1))
 
 	// Parse blocks from data
-	body, err := parseBlocks(ctx, bodyData)
+	body, err := parseBlocks(bodyCtx, bodyData)
+	if err = parseError(bodyCtx, err); err != nil {
+		return nil, err
+	}
+
+	vars, err := parseScopeVars(varsCtx, varsData, key == "$let")
+	if err != nil {
+		return nil, err
+	}
+	return NewWithBlockVars(vars, NewDataBlock(body)), nil
+}
+
+// parseScopeVars parses the assignment `name := expr`, the list of assignments
+// or the map of names and expressions if isMap is true
+func parseScopeVars(ctx context.Context, data any, isMap bool) ([]*WithVar, error) {
+	names, exprs, ctxs, err := splitScopeVars(ctx, data, isMap)
+	if err != nil {
+		return nil, err
+	}
+	ctxs, _ = ctxWithScopeVars(ctxs, names, exprs, ctx)
+
+	vars := make([]*WithVar, 0, len(names))
+	for i, name := range names {
+		program, err := compileExpr(ctxs[i], exprs[i])
+		if err != nil {
+			if err = parseError(ctxs[i], errors.Wrap(err, exprs[i])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		vars = append(vars, &WithVar{Name: name, Expr: program})
+	}
+	return vars, nil
+}
+
+// ctxWithScopeVars returns contexts of variable expressions and the body with variables typed by the schema,
+// every variable sees the previous ones. Contexts are returned as is if the template has no schema.
+func ctxWithScopeVars(ctxs []context.Context, names, exprs []string, bodyCtx context.Context) ([]context.Context, context.Context) {
+	if ctxSchema(bodyCtx) == nil {
+		return ctxs, bodyCtx
+	}
+	var (
+		vars    []schemaVar
+		varCtxs = make([]context.Context, len(ctxs))
+	)
+	for i, name := range names {
+		varCtxs[i] = ctxWithSchemaVars(ctxs[i], vars...)
+		vars = append(vars, schemaVar{name: name, typ: schemaExprType(varCtxs[i], exprs[i])})
+	}
+	return varCtxs, ctxWithSchemaVars(bodyCtx, vars...)
+}
+
+// splitScopeVars returns names, expressions and contexts of scope variables
+func splitScopeVars(ctx context.Context, data any, isMap bool) (names, exprs []string, ctxs []context.Context, err error) {
+	switch {
+	case isMap && !gocast.IsSlice(data):
+		if !isMapData(data) {
+			return nil, nil, nil, newTemplateError(ctx, errors.Wrap(errInvalidWithBlockExpr, "map or list of variables expected"))
+		}
+		vars := toOrderedMap(data)
+		for _, name := range vars.keys {
+			if !reVariableName.MatchString(name) {
+				return nil, nil, nil, newTemplateError(ctxWithPath(ctx, name), errors.Wrap(errInvalidWithBlockExpr, "invalid variable name "+name))
+			}
+			names = append(names, name)
+			exprs = append(exprs, gocast.Str(vars.values[name]))
+			ctxs = append(ctxs, ctxWithPath(ctx, name))
+		}
+		// Keys of the unordered map are just sorted, so variables are evaluated in the order of dependencies
+		if vars.sorted {
+			if err = sortScopeVars(names, exprs, ctxs); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	case gocast.IsSlice(data):
+		for i, item := range gocast.AnySlice[any](data) {
+			itemCtx := ctxWithPath(ctx, i)
+			varArr := reLeftVariableName.FindStringSubmatch(gocast.Str(item))
+			if len(varArr) < 2 {
+				return nil, nil, nil, newTemplateError(itemCtx, errors.Wrap(errInvalidWithBlockExpr, gocast.Str(item)))
+			}
+			names = append(names, varArr[1])
+			exprs = append(exprs, strings.TrimSpace(strings.Replace(gocast.Str(item), varArr[0], "", 1)))
+			ctxs = append(ctxs, itemCtx)
+		}
+	default:
+		withExpr := gocast.Str(data)
+		varArr := reLeftVariableName.FindStringSubmatch(withExpr)
+		if len(varArr) < 2 {
+			return nil, nil, nil, errors.Wrap(errInvalidWithBlockExpr, withExpr)
+		}
+		names = append(names, varArr[1])
+		exprs = append(exprs, strings.TrimSpace(strings.Replace(withExpr, varArr[0], "", 1)))
+		ctxs = append(ctxs, ctx)
+	}
+	if len(names) == 0 {
+		return nil, nil, nil, errors.Wrap(errInvalidWithBlockExpr, "no variables")
+	}
+	return names, exprs, ctxs, nil
+}
+
+// sortScopeVars reorders variables so every variable goes after the variables used by its expression,
+// the error is returned if variables depend on each other
+func sortScopeVars(names, exprs []string, ctxs []context.Context) error {
+	deps := make([][]int, len(names))
+	for i := range names {
+		refs := exprIdentifiers(exprs[i])
+		for j, name := range names {
+			if j != i && refs[name] {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	var (
+		order = make([]int, 0, len(names))
+		state = make([]int, len(names)) // 0 - new, 1 - in progress, 2 - done
+		visit func(i int) error
+	)
+	visit = func(i int) error {
+		switch state[i] {
+		case 1:
+			return newTemplateError(ctxs[i], errors.Wrap(errInvalidWithBlockExpr, "cyclic reference of variable "+names[i]))
+		case 2:
+			return nil
+		}
+		state[i] = 1
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		state[i] = 2
+		order = append(order, i)
+		return nil
+	}
+	for i := range names {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	sortedNames := make([]string, len(names))
+	sortedExprs := make([]string, len(names))
+	sortedCtxs := make([]context.Context, len(names))
+	for i, j := range order {
+		sortedNames[i], sortedExprs[i], sortedCtxs[i] = names[j], exprs[j], ctxs[j]
+	}
+	copy(names, sortedNames)
+	copy(exprs, sortedExprs)
+	copy(ctxs, sortedCtxs)
+	return nil
+}
+
+// exprIdentifiers returns names used by the expression, invalid expressions have no names
+// as their errors are reported by the compilation
+func exprIdentifiers(expression string) map[string]bool {
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return nil
+	}
+	collector := &identCollector{names: map[string]bool{}}
+	ast.Walk(&tree.Node, collector)
+	return collector.names
+}
+
+type identCollector struct {
+	names map[string]bool
+}
+
+func (c *identCollector) Visit(node *ast.Node) {
+	if ident, ok := (*node).(*ast.IdentifierNode); ok {
+		c.names[ident.Value] = true
+	}
+}
+
+// Example 1:
+// $switch: env
+// $case:
+//
+//	prod:
+//		replicas: 3
+//	dev:
+//		replicas: 1
+//
+// $default:
+//
+//	replicas: 0
+//
+// Example 2:
+// $switch: port := item.port
+// $case:
+//
+//   - $value: [80, 8080]
+//     scheme: http
+//   - $cond: "port > 1024"
+//     $body: custom
+//
+// $default: unknown
+//
+// Example 3:
+// $switch:
+//
+//	$expr: env
+//	$case:
+//		prod: 3
+//		dev: 1
+//	$default: 0
+func parseSwitchBlock(ctx context.Context, data *OrderedMap) (Block, error) {
+	var (
+		switchData, ok = data.Lookup("$switch")
+		switchExpr     string
+		varName        string
+		caseData       any
+		defaultData    any
+		casesCtx       = ctx
+	)
+	if !ok {
+		return nil, errInvalidSwitchBlock
+	}
+
+	if gocast.IsStr(switchData) {
+		switchExpr = gocast.Str(switchData)
+		if err := checkSwitchFields(ctxWithPath(ctx, "$switch"), data, "$switch", "$case", "$default"); err != nil {
+			return nil, err
+		}
+		caseData, defaultData = data.Get("$case"), data.Get("$default")
+	} else {
+		switchMap := toOrderedMap(switchData)
+		switchExpr = gocast.Str(switchMap.Get("$expr"))
+		casesCtx = ctxWithPath(ctx, "$switch")
+		if err := checkSwitchFields(casesCtx, data, "$switch"); err != nil {
+			return nil, err
+		}
+		if err := checkSwitchFields(casesCtx, switchMap, "$expr", "$case", "$default"); err != nil {
+			return nil, err
+		}
+		caseData, defaultData = switchMap.Get("$case"), switchMap.Get("$default")
+	}
+
+	if varArr := reLeftVariableName.FindStringSubmatch(switchExpr); len(varArr) == 2 {
+		varName = varArr[1]
+		switchExpr = strings.TrimSpace(strings.Replace(switchExpr, varArr[0], "", 1))
+		casesCtx = ctxWithSchemaVars(casesCtx, schemaVar{name: varName, typ: schemaExprType(ctx, switchExpr)})
+	}
+
+	cases, err := parseSwitchCases(ctxWithPath(casesCtx, "$case"), caseData)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewWithBlockFromExpr(ctx, varArr[1], withExpr, NewDataBlock(body))
+	var defaultBlock Block
+	if defaultData != nil {
+		defaultCtx := ctxWithPath(casesCtx, "$default")
+		body, err := parseBlocks(defaultCtx, defaultData)
+		if err = parseError(defaultCtx, err); err != nil {
+			return nil, err
+		}
+		defaultBlock = NewDataBlock(body)
+	}
+
+	if switchExpr == "" {
+		return nil, errors.Wrap(errInvalidSwitchBlock, "empty expression")
+	}
+
+	return NewSwitchBlockFromExpr(ctx, varName, switchExpr, cases, defaultBlock)
+}
+
+// checkSwitchFields returns the error if the switch map has fields except allowed ones
+func checkSwitchFields(ctx context.Context, data *OrderedMap, allowed ...string) error {
+	for _, key := range data.keys {
+		if !hasString(allowed, key) {
+			if err := parseError(ctx, errors.Wrap(errInvalidSwitchBlock, "unexpected field "+key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseSwitchCases(ctx context.Context, data any) ([]*SwitchCase, error) {
+	switch {
+	case data == nil:
+		return nil, nil
+	case gocast.IsSlice(data):
+		list := gocast.AnySlice[any](data)
+		cases := make([]*SwitchCase, 0, len(list))
+		for i, item := range list {
+			itemCtx := ctxWithPath(ctx, i)
+			if !isMapData(item) {
+				if err := parseError(itemCtx, errors.Wrap(errInvalidSwitchCase, "case must be a map")); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switchCase, err := parseSwitchCase(itemCtx, toOrderedMap(item))
+			if err != nil {
+				if err = parseError(itemCtx, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			cases = append(cases, switchCase)
+		}
+		return cases, nil
+	case isMapData(data):
+		mp := toOrderedMap(data)
+		cases := make([]*SwitchCase, 0, mp.Len())
+		for _, key := range mp.keys {
+			keyCtx := ctxWithPath(ctx, key)
+			body, err := parseBlocks(keyCtx, mp.values[key])
+			if err = parseError(keyCtx, err); err != nil {
+				return nil, err
+			}
+			cases = append(cases, &SwitchCase{Values: []any{key}, Body: NewDataBlock(body)})
+		}
+		return cases, nil
+	}
+	return nil, newTemplateError(ctx, errors.Wrap(errInvalidSwitchCase, "cases must be a list or a map"))
+}
+
+func parseSwitchCase(ctx context.Context, data *OrderedMap) (*SwitchCase, error) {
+	var (
+		dataCopy        = data.Copy()
+		value, hasValue = dataCopy.Lookup("$value")
+		cond, hasCond   = dataCopy.Lookup("$cond")
+		switchCase      = &SwitchCase{}
+		bodyData        any
+	)
+	if hasValue == hasCond {
+		return nil, errors.Wrap(errInvalidSwitchCase, "one of $value or $cond is required")
+	}
+	dataCopy.Delete("$value", "$cond")
+
+	if hasCond {
+		program, err := compileExpr(ctx, gocast.Str(cond))
+		if err != nil {
+			if err = parseError(ctxWithPath(ctx, "$cond"), errors.Wrap(err, gocast.Str(cond))); err != nil {
+				return nil, err
+			}
+		}
+		switchCase.Cond = program
+	} else if gocast.IsSlice(value) {
+		switchCase.Values = gocast.AnySlice[any](value)
+	} else {
+		switchCase.Values = []any{value}
+	}
+
+	// If body is defined then we should not have any other fields
+	var ok bool
+	bodyCtx := ctx
+	if bodyData, ok = dataCopy.Lookup("$body"); ok {
+		if err := checkBodyFields(ctx, ctx, dataCopy); err != nil {
+			return nil, err
+		}
+		bodyCtx = ctxWithPath(ctx, "$body")
+	} else {
+		bodyData = dataCopy
+	}
+
+	body, err := parseBlocks(bodyCtx, bodyData)
+	if err = parseError(bodyCtx, err); err != nil {
+		return nil, err
+	}
+	switchCase.Body = NewDataBlock(body)
+	return switchCase, nil
+}
+
+// Example 1:
+// $include: sidecar
+//
+// Example 2:
+// $include: sidecar
+// $with: image := service.image
+//
+// Example 3:
+// $include: sidecar.yaml
+// $with:
+//
+//	image: "{{service.image}}"
+//	port: 8080
+func parseIncludeBlock(ctx context.Context, data *OrderedMap) (Block, error) {
+	var (
+		includeData, _ = data.Lookup("$include")
+		name           = gocast.Str(includeData)
+		state          = ctxGetOptions(ctx).include
+	)
+	if !gocast.IsStr(includeData) || name == "" {
+		return nil, errors.Wrap(errInvalidIncludeBlock, "template name expected")
+	}
+	for _, key := range data.keys {
+		if key != "$include" && key != "$with" {
+			if err := parseError(ctxWithPath(ctx, "$include"), errors.Wrap(errInvalidIncludeBlock, "unexpected field "+key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if state == nil {
+		return nil, errors.Wrap(errInvalidIncludeBlock, "$include is supported only by the template set")
+	}
+
+	var vars []*IncludeVar
+	if withData, ok := data.Lookup("$with"); ok {
+		withCtx := ctxWithPath(ctx, "$with")
+		switch {
+		case gocast.IsStr(withData):
+			withExpr := gocast.Str(withData)
+			varArr := reLeftVariableName.FindStringSubmatch(withExpr)
+			if len(varArr) < 2 {
+				return nil, newTemplateError(withCtx, errors.Wrap(errInvalidWithBlockExpr, withExpr))
+			}
+			value, err := NewExprBlockFromExpr(withCtx, strings.TrimSpace(strings.Replace(withExpr, varArr[0], "", 1)), false)
+			if err != nil {
+				return nil, newTemplateError(withCtx, err)
+			}
+			setBlockSource(withCtx, value)
+			vars = append(vars, &IncludeVar{Name: varArr[1], Value: value})
+		case isMapData(withData):
+			withMap := toOrderedMap(withData)
+			for _, key := range withMap.keys {
+				value, err := ParseBody(withCtx, key, withMap.values[key])
+				if err != nil {
+					return nil, err
+				}
+				vars = append(vars, &IncludeVar{Name: key, Value: value})
+			}
+		default:
+			return nil, newTemplateError(withCtx, errors.Wrap(errInvalidIncludeBlock, "$with must be a map or an expression"))
+		}
+	}
+
+	includeCtx := ctx
+	for _, v := range vars {
+		includeCtx = ctxWithSchemaVars(includeCtx, schemaAnyVars(v.Name)...)
+	}
+	body, err := state.parseInclude(includeCtx, name)
+	if err != nil {
+		return nil, err
+	}
+	return NewIncludeBlock(name, vars, NewDataBlock(body)), nil
+}
+
+// Example:
+// $define:
+//
+//	name: port
+//	params: [name, number]
+//	body:
+//	  name: "{{name}}"
+//	  containerPort: "{{number}}"
+//
+// http:
+//
+//	$call: port
+//	$args: [http, 80]
+//
+// https:
+//
+//	$call: port
+//	$args: {name: https, number: 443}
+//
+// The `$define` is allowed only at the root of the template and can be a list or a single map.
+// All macros are registered before their bodies are parsed, so macros can call each other recursively.
+func parseDefineBlock(ctx context.Context, data *OrderedMap) (any, error) {
+	var (
+		defineCtx  = ctxWithPath(ctx, "$define")
+		defineData = data.Get("$define")
+		list       []any
+		macros     = map[string]*Macro{}
+		bodies     = map[*Macro]any{}
+		ctxs       = map[*Macro]context.Context{}
+		order      []*Macro
+	)
+	for name, macro := range ctxMacros(ctx) {
+		macros[name] = macro
+	}
+	switch {
+	case gocast.IsSlice(defineData):
+		list = gocast.AnySlice[any](defineData)
+	case isMapData(defineData):
+		list = []any{defineData}
+	default:
+		return nil, newTemplateError(defineCtx, errors.Wrap(errInvalidDefineBlock, "macro definition must be a map or a list"))
+	}
+
+	for i, item := range list {
+		itemCtx := defineCtx
+		if gocast.IsSlice(defineData) {
+			itemCtx = ctxWithPath(defineCtx, i)
+		}
+		macro, body, err := parseMacroDefinition(item)
+		if err == nil {
+			// Macros of the including template can be redefined, but not the ones of the same template
+			if _, ok := ctxs[macros[macro.Name]]; ok {
+				err = errors.Wrap(errInvalidDefineBlock, "duplicate macro "+macro.Name)
+			}
+		}
+		if err != nil {
+			if err = parseError(itemCtx, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		bodyCtx := ctxWithSchemaVars(ctxWithPath(itemCtx, "body"), schemaAnyVars(macro.Params...)...)
+		macros[macro.Name], bodies[macro], ctxs[macro] = macro, body, bodyCtx
+		order = append(order, macro)
+	}
+
+	ctx = context.WithValue(ctx, ctxMacrosKey, macros)
+	for _, macro := range order {
+		bodyCtx := context.WithValue(ctxs[macro], ctxMacrosKey, macros)
+		body, err := parseBlocks(bodyCtx, bodies[macro])
+		if err = parseError(bodyCtx, err); err != nil {
+			return nil, err
+		}
+		macro.Body = NewDataBlock(body)
+	}
+	return parseBlocks(ctx, data.Copy().Delete("$define"))
+}
+
+// parseMacroDefinition parses the map with name, params and body of the macro
+func parseMacroDefinition(data any) (*Macro, any, error) {
+	if !isMapData(data) {
+		return nil, nil, errors.Wrap(errInvalidDefineBlock, "macro definition must be a map")
+	}
+	var (
+		def  = toOrderedMap(data)
+		name = gocast.Str(def.Get("name"))
+	)
+	for _, key := range def.keys {
+		if key != "name" && key != "params" && key != "body" {
+			return nil, nil, errors.Wrap(errInvalidDefineBlock, "unexpected field "+key)
+		}
+	}
+	if !reVariableName.MatchString(name) {
+		return nil, nil, errors.Wrap(errInvalidDefineBlock, "invalid macro name "+name)
+	}
+	macro := &Macro{Name: name}
+	if params, ok := def.Lookup("params"); ok && params != nil {
+		if !gocast.IsSlice(params) {
+			return nil, nil, errors.Wrap(errInvalidDefineBlock, "params must be a list of names")
+		}
+		for _, param := range gocast.AnySlice[any](params) {
+			paramName := gocast.Str(param)
+			if !reVariableName.MatchString(paramName) || hasString(macro.Params, paramName) {
+				return nil, nil, errors.Wrap(errInvalidDefineBlock, "invalid parameter name "+paramName)
+			}
+			macro.Params = append(macro.Params, paramName)
+		}
+	}
+	return macro, def.Get("body"), nil
+}
+
+// Example 1:
+// $call: port
+// $args: [http, 80]
+//
+// Example 2:
+// $call: port
+// $args:
+//
+//	name: https
+//	number: "{{item.port}}"
+func parseCallBlock(ctx context.Context, data *OrderedMap) (Block, error) {
+	var (
+		name     = gocast.Str(data.Get("$call"))
+		argsData = data.Get("$args")
+		argsCtx  = ctxWithPath(ctx, "$args")
+		macro    = ctxMacros(ctx)[name]
+	)
+	for _, key := range data.keys {
+		if key != "$call" && key != "$args" {
+			return nil, errors.Wrap(errInvalidCallBlock, "unexpected field "+key)
+		}
+	}
+	if macro == nil {
+		return nil, errors.Wrap(errUnknownMacro, name)
+	}
+
+	args := make([]Block, len(macro.Params))
+	switch {
+	case argsData == nil:
+		if len(macro.Params) > 0 {
+			return nil, errors.Wrapf(errInvalidCallBlock, "%s expects %d arguments, got 0", name, len(macro.Params))
+		}
+	case gocast.IsSlice(argsData):
+		list := gocast.AnySlice[any](argsData)
+		if len(list) != len(macro.Params) {
+			return nil, errors.Wrapf(errInvalidCallBlock, "%s expects %d arguments, got %d", name, len(macro.Params), len(list))
+		}
+		for i, arg := range list {
+			block, err := ParseBody(argsCtx, strconv.Itoa(i), arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = block
+		}
+	case isMapData(argsData):
+		argsMap := toOrderedMap(argsData)
+		for _, key := range argsMap.keys {
+			if !hasString(macro.Params, key) {
+				return nil, newTemplateError(ctxWithPath(argsCtx, key), errors.Wrapf(errInvalidCallBlock, "%s has no parameter %s", name, key))
+			}
+		}
+		for i, param := range macro.Params {
+			arg, ok := argsMap.Lookup(param)
+			if !ok {
+				return nil, errors.Wrapf(errInvalidCallBlock, "%s expects argument %s", name, param)
+			}
+			block, err := ParseBody(argsCtx, param, arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = block
+		}
+	default:
+		return nil, newTemplateError(argsCtx, errors.Wrap(errInvalidCallBlock, "$args must be a list or a map"))
+	}
+	return NewCallBlock(macro, args), nil
+}
+
+// ctxMacros returns macros defined in the template from the parse context
+func ctxMacros(ctx context.Context) map[string]*Macro {
+	macros, _ := ctx.Value(ctxMacrosKey).(map[string]*Macro)
+	return macros
 }
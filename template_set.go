@@ -0,0 +1,203 @@
+package datatemplate
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errTemplateNotFound = errors.New("template not found")
+	errIncludeCycle     = errors.New("include cycle")
+)
+
+// TemplateSet contains named templates which can include each other with the `$include` directive.
+// Includes are resolved at the parse time, so every template of the set is parsed on the first use.
+type TemplateSet struct {
+	mx        sync.Mutex
+	opts      []Option
+	sources   map[string]*templateSource
+	templates map[string]*Template
+}
+
+type templateSource struct {
+	data any
+	src  *sourceMap
+}
+
+// includeState is the state of the template set parse
+type includeState struct {
+	set  *TemplateSet
+	name string
+
+	// Parsed blocks of included templates by name and parse scope
+	blocks map[includeKey]any
+}
+
+// includeKey identifies the included template parsed in the scope of the including one,
+// as schema variables and macros of the scope are resolved at the parse time
+type includeKey struct {
+	name   string
+	schema string
+	macros uintptr
+}
+
+// NewTemplateSet creates new empty template set, options are applied to all templates of the set
+func NewTemplateSet(opts ...Option) *TemplateSet {
+	return &TemplateSet{
+		opts:      opts,
+		sources:   map[string]*templateSource{},
+		templates: map[string]*Template{},
+	}
+}
+
+// Add the template data (string, map, *yaml.Node, etc) to the set with the name,
+// parsed templates of the set are dropped as they can include the added one
+func (s *TemplateSet) Add(name string, data any) *TemplateSet {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sources[name] = &templateSource{data: data, src: &sourceMap{file: name}}
+	s.reset()
+	return s
+}
+
+// ParseFS adds all files of the file system which match the pattern to the set,
+// templates are named by file names and can be included with or without the file extension
+func (s *TemplateSet) ParseFS(fsys fs.FS, pattern string) error {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		format, err := FormatByFileName(name)
+		if err != nil {
+			return err
+		}
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		src := &sourceMap{file: name}
+		data, err := decodeSource(file, format, src)
+		_ = file.Close()
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		s.mx.Lock()
+		s.sources[name] = &templateSource{data: data, src: src}
+		s.reset()
+		s.mx.Unlock()
+	}
+	return nil
+}
+
+// reset drops parsed templates, as any of them can include the changed one
+func (s *TemplateSet) reset() {
+	s.templates = map[string]*Template{}
+}
+
+// Names returns the sorted list of template names of the set
+func (s *TemplateSet) Names() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Template returns the parsed template of the set by name
+func (s *TemplateSet) Template(name string) (*Template, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	source, name := s.lookup(name)
+	if source == nil {
+		return nil, errors.Wrap(errTemplateNotFound, name)
+	}
+	if tpl := s.templates[name]; tpl != nil {
+		return tpl, nil
+	}
+	opts := append(s.opts[:len(s.opts):len(s.opts)],
+		withSourceMap(source.src),
+		withIncludeState(&includeState{set: s, name: name, blocks: map[includeKey]any{}}))
+	tpl, err := NewTemplateFor(source.data, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.templates[name] = tpl
+	return tpl, nil
+}
+
+// lookup returns the source of the template by the name or by the file name without extension
+func (s *TemplateSet) lookup(name string) (*templateSource, string) {
+	if source := s.sources[name]; source != nil {
+		return source, name
+	}
+	keys := make([]string, 0, len(s.sources))
+	for key := range s.sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.TrimSuffix(key, path.Ext(key)) == name {
+			return s.sources[key], key
+		}
+	}
+	return nil, name
+}
+
+// parseInclude parses the included template of the set in the parse context of the including one.
+// The name is resolved relative to the directory of the including template first.
+// The included template has its own paths and positions in errors.
+func (st *includeState) parseInclude(ctx context.Context, name string) (any, error) {
+	stack := ctxIncludeStack(ctx)
+	if len(stack) == 0 {
+		stack = []string{st.name}
+	}
+	var (
+		source *templateSource
+		key    string
+	)
+	if dir := path.Dir(stack[len(stack)-1]); dir != "." {
+		source, key = st.set.lookup(path.Join(dir, name))
+	}
+	if source == nil {
+		if source, key = st.set.lookup(name); source == nil {
+			return nil, errors.Wrap(errTemplateNotFound, name)
+		}
+	}
+	name = key
+	if hasString(stack, name) {
+		return nil, errors.Wrap(errIncludeCycle, strings.Join(append(stack, name), " -> "))
+	}
+	cacheKey := includeKey{
+		name:   name,
+		schema: ctxSchema(ctx).key(),
+		macros: reflect.ValueOf(ctxMacros(ctx)).Pointer(),
+	}
+	if block, ok := st.blocks[cacheKey]; ok {
+		return block, nil
+	}
+	ctx = context.WithValue(ctx, ctxIncludeStackKey, append(stack[:len(stack):len(stack)], name))
+	ctx = ctxWithSourceMap(context.WithValue(ctx, ctxPathKey, ""), source.src)
+	block, err := parseBlocks(ctx, source.data)
+	if err = parseError(ctx, err); err != nil {
+		return nil, err
+	}
+	st.blocks[cacheKey] = block
+	return block, nil
+}
+
+// ctxIncludeStack returns names of templates which are included at the moment
+func ctxIncludeStack(ctx context.Context) []string {
+	stack, _ := ctx.Value(ctxIncludeStackKey).([]string)
+	return stack
+}
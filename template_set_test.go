@@ -0,0 +1,160 @@
+package datatemplate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateSet(t *testing.T) {
+	fsys := fstest.MapFS{
+		"tpl/deployment.yaml": {Data: []byte(`name: "{{name}}"
+containers:
+  - name: app
+    image: "{{image}}"
+  - $include: sidecar
+    $with:
+      port: 9090
+`)},
+		"tpl/sidecar.yaml": {Data: []byte(`name: "proxy-{{name}}"
+image: envoy
+port: "{{port}}"
+`)},
+		"tpl/a.json": {Data: []byte(`{"b": {"$include": "tpl/b"}}`)},
+		"tpl/b.json": {Data: []byte(`{"a": {"$include": "tpl/a.json"}}`)},
+	}
+	set := NewTemplateSet()
+	if !assert.NoError(t, set.ParseFS(fsys, "tpl/*")) {
+		return
+	}
+	set.Add("broken", map[string]any{"items": []any{map[string]any{"$include": "tpl/sidecar", "$with": "port := 8080"}, "{{a +}}"}})
+	set.Add("missing", map[string]any{"item": map[string]any{"$include": "unknown"}})
+	assert.Equal(t, []string{"broken", "missing", "tpl/a.json", "tpl/b.json", "tpl/deployment.yaml", "tpl/sidecar.yaml"}, set.Names())
+
+	tpl, err := set.Template("tpl/deployment")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"name": "web", "image": "nginx"})
+	if assert.NoError(t, err) {
+		containers := res.(*OrderedMap).Get("containers").([]any)
+		assert.Equal(t, []string{"name", "image", "port"}, containers[1].(*OrderedMap).Keys())
+		assert.Equal(t, map[string]any{"name": "proxy-web", "image": "envoy", "port": 9090}, containers[1].(*OrderedMap).Map())
+	}
+
+	same, err := set.Template("tpl/deployment.yaml")
+	assert.NoError(t, err)
+	assert.Same(t, tpl, same)
+
+	_, err = set.Template("tpl/a")
+	if assert.ErrorIs(t, err, errIncludeCycle) {
+		assert.Contains(t, err.Error(), "tpl/a.json -> tpl/b.json -> tpl/a.json")
+	}
+
+	_, err = set.Template("missing")
+	var tplErr *TemplateError
+	if assert.ErrorIs(t, err, errTemplateNotFound) && assert.True(t, errors.As(err, &tplErr)) {
+		assert.Equal(t, "missing", tplErr.File)
+		assert.Equal(t, "/item/$include", tplErr.Path)
+	}
+
+	_, err = set.Template("broken")
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "broken", tplErr.File)
+		assert.Equal(t, "/items/1", tplErr.Path)
+	}
+
+	_, err = set.Template("unknown")
+	assert.ErrorIs(t, err, errTemplateNotFound)
+}
+
+func TestTemplateSetIncludeMapVars(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.yaml": {Data: []byte(`app:
+  $include: container
+  $with: {cfg: {image: nginx, ports: [{port: "{{port}}"}]}}
+`)},
+		"container.yaml": {Data: []byte(`image: "{{cfg.image}}"
+port: "{{cfg.ports[0].port}}"
+`)},
+	}
+	set := NewTemplateSet()
+	if !assert.NoError(t, set.ParseFS(fsys, "*.yaml")) {
+		return
+	}
+	tpl, err := set.Template("main")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := tpl.Process(context.Background(), map[string]any{"port": 80})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"image": "nginx", "port": 80}, res.(*OrderedMap).Get("app").(*OrderedMap).Map())
+	}
+}
+
+func TestTemplateSetIncludeErrors(t *testing.T) {
+	set := NewTemplateSet().
+		Add("main", map[string]any{"sidecar": map[string]any{"$include": "sidecar", "$with": "port := 8080"}}).
+		Add("sidecar", map[string]any{"port": "{{port / 0 + name}}"})
+
+	tpl, err := set.Template("main")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "{sidecar: $include: {`$name`: sidecar, $with: {port: `8080`}, $body: {port: `port / 0 + name`}}}", tpl.String())
+
+	_, err = tpl.Process(context.Background(), map[string]any{"name": "web"})
+	var tplErr *TemplateError
+	if assert.True(t, errors.As(err, &tplErr), err) {
+		assert.Equal(t, "sidecar", tplErr.File)
+		assert.Equal(t, "/port", tplErr.Path)
+	}
+
+	_, err = NewTemplateFor(map[string]any{"$include": "sidecar"})
+	assert.ErrorIs(t, err, errInvalidIncludeBlock)
+}
+
+func TestTemplateSetIncludeCache(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		set := NewTemplateSet().
+			Add("page", map[string]any{"header": map[string]any{"$include": "header"}}).
+			Add("header", "v1")
+		tpl, err := set.Template("page")
+		if !assert.NoError(t, err) {
+			return
+		}
+		res, err := tpl.Process(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{"header": "v1"}, res)
+
+		set.Add("header", "v2")
+		tpl, err = set.Template("page")
+		if !assert.NoError(t, err) {
+			return
+		}
+		res, err = tpl.Process(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{"header": "v2"}, res)
+	})
+
+	t.Run("scope", func(t *testing.T) {
+		schema, err := SchemaFromExample(map[string]any{"people": []any{map[string]any{"name": "tony"}}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		// The partial is valid in the loop scope only, so the second include must be checked again
+		set := NewTemplateSet(WithSchema(schema)).
+			Add("page", map[string]any{
+				"a": map[string]any{"$iterate": "people", "$body": map[string]any{"$include": "person"}},
+				"b": map[string]any{"$include": "person"},
+			}).
+			Add("person", "{{item.name}}")
+		_, err = set.Template("page")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "unknown name item")
+		}
+	})
+}
@@ -2,9 +2,11 @@ package datatemplate
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/demdxx/gocast/v2"
 	"github.com/stretchr/testify/assert"
@@ -143,6 +145,37 @@ func TestTemplate(t *testing.T) {
 					},
 				},
 			},
+			{
+				tpl: map[string]any{
+					"group": map[string]any{
+						"$if": map[string]any{
+							"$cond": "age < 18",
+							"name":  "teenager",
+						},
+						"$elif": []any{
+							map[string]any{"$cond": "age < 30", "name": "young"},
+							map[string]any{"$cond": "age < 50", "name": "adult"},
+						},
+						"$else": map[string]any{"name": "senior"},
+					},
+				},
+				res: map[string]any{
+					"group": map[string]any{"name": "adult"},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"group": map[string]any{
+						"$if":   "age > 50",
+						"$elif": map[string]any{"$cond": "age > 40", "$body": "{{name}}"},
+						"$else": "nobody",
+						"name":  "senior",
+					},
+				},
+				res: map[string]any{
+					"group": "tony",
+				},
+			},
 			// Iterate statement tests
 			{
 				tpl: map[string]any{
@@ -218,6 +251,194 @@ func TestTemplate(t *testing.T) {
 					},
 				},
 			},
+			// Switch statement tests
+			{
+				tpl: map[string]any{
+					"group": map[string]any{
+						"$switch": "person[0].name",
+						"$case": map[string]any{
+							"tony": "avengers",
+							"rony": "wizards",
+						},
+						"$default": "unknown",
+					},
+				},
+				res: map[string]any{
+					"group": "avengers",
+				},
+			},
+			{
+				tpl: map[string]any{
+					"persons": map[string]any{
+						"$iterate": "person",
+						"$body": map[string]any{
+							"$switch": "age := item.age",
+							"$case": []any{
+								map[string]any{"$value": 42, "$body": "{{item.name}} is 42"},
+								map[string]any{"$cond": "age < 18", "teen": "{{item.name}}"},
+							},
+						},
+					},
+				},
+				res: map[string]any{
+					"persons": []any{
+						"tony is 42",
+						map[string]any{"teen": "rony"},
+					},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"group": map[string]any{
+						"$switch": map[string]any{
+							"$expr":    "age",
+							"$case":    []any{map[string]any{"$value": []any{1, 2}, "$body": "baby"}},
+							"$default": map[string]any{"adult": true},
+						},
+					},
+				},
+				res: map[string]any{
+					"group": map[string]any{"adult": true},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"pairs": map[string]any{
+						"$iterate": "p, i := person",
+						"$body": map[string]any{
+							"$iterate": "person",
+							"$value":   "q",
+							"$index":   "j",
+							"$body":    "{{p.name}}-{{q.name}}: {{i}}{{j}}",
+						},
+					},
+				},
+				res: map[string]any{
+					"pairs": []any{
+						[]any{"tony-tony: 00", "tony-rony: 01"},
+						[]any{"rony-tony: 10", "rony-rony: 11"},
+					},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"fields": map[string]any{
+						"$iterate": map[string]any{
+							"$expr":  "v, i, k := person[1]",
+							"$body":  "{{pos}}",
+							"$index": "pos",
+						},
+					},
+				},
+				res: map[string]any{
+					"fields": []any{0, 1},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"names": map[string]any{
+						"$iterate": "person",
+						"$where":   "item.age > 10",
+						"$sortBy":  "item.age asc",
+						"$limit":   1,
+						"$body":    "{{item.name}}",
+					},
+				},
+				res: map[string]any{
+					"names": []any{"rony"},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"names": map[string]any{
+						"$iterate": map[string]any{
+							"$expr":   "person",
+							"$sortBy": map[string]any{"$expr": "item.name", "$order": "desc"},
+							"$offset": "1",
+							"name":    "{{item.name}}",
+						},
+					},
+				},
+				res: map[string]any{
+					"names": []any{map[string]any{"name": "rony"}},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"persons": map[string]any{
+						"$iterate": "person",
+						"$outKey":  "{{item.name}}",
+						"age":      "{{item.age}}",
+					},
+				},
+				res: map[string]any{
+					"persons": map[string]any{
+						"tony": map[string]any{"age": 42},
+						"rony": map[string]any{"age": 14},
+					},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"ages": map[string]any{
+						"$iterate": "person[0]",
+						"$as":      "map",
+						"$body":    "{{s= item}}",
+					},
+				},
+				res: map[string]any{
+					"ages": map[string]any{"name": "tony", "age": "42"},
+				},
+			},
+			// Spread statement tests
+			{
+				tpl: map[string]any{
+					"names": []any{
+						"nobody",
+						map[string]any{
+							"$spread": map[string]any{
+								"$iterate": "person",
+								"$body":    "{{item.name}}",
+							},
+						},
+						"somebody",
+					},
+				},
+				res: map[string]any{
+					"names": []any{"nobody", "tony", "rony", "somebody"},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"ages": map[string]any{
+						"total": "{{len(person)}}",
+						"$...": map[string]any{
+							"$iterate": "person",
+							"$outKey":  "{{item.name}}",
+							"$body":    "{{item.age}}",
+						},
+					},
+				},
+				res: map[string]any{
+					"ages": map[string]any{"total": 2, "tony": 42, "rony": 14},
+				},
+			},
+			{
+				tpl: map[string]any{
+					"name": "{{name}}",
+					"ages": map[string]any{
+						"$spread": map[string]any{
+							"$iterate": "person",
+							"$outKey":  "{{item.name}}",
+							"$body":    "{{item.age}}",
+						},
+					},
+				},
+				res: map[string]any{
+					"name": "tony",
+					"ages": map[string]any{"tony": 42, "rony": 14},
+				},
+			},
 			// With statement tests
 			{
 				tpl: map[string]any{
@@ -312,3 +533,154 @@ func TestTemplateStr(t *testing.T) {
 		assert.True(t, strings.HasPrefix(tmp.String(), `{persons: $iterate: {`))
 	}
 }
+
+func TestTemplateKeyOrder(t *testing.T) {
+	tpl := map[string]any{
+		"ports": map[string]any{
+			"$iterate": "ports",
+			"$body":    "{{key}}={{item}}",
+		},
+		"name":   "{{name}}",
+		"static": "value",
+	}
+	data := map[string]any{
+		"name":  "web",
+		"ports": map[string]any{"http": 80, "https": 443, "admin": 8080},
+	}
+
+	tmp, err := NewTemplateFor(tpl)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "{name: `name`, ports: $iterate: {`$expr`: `ports`, $index: `index`, $key: `key`, "+
+			"$value: `item`, $body: {{key}}={{item}}}, static: value}", tmp.String())
+		res, err := tmp.Process(context.Background(), data)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"admin=8080", "http=80", "https=443"}, res.(map[string]any)["ports"])
+	}
+
+	tmp, err = NewTemplateFor(tpl, WithMapKeyOrder(func(a, b string) bool { return len(a) < len(b) }))
+	if assert.NoError(t, err) {
+		res, err := tmp.Process(context.Background(), data)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"http=80", "admin=8080", "https=443"}, res.(map[string]any)["ports"])
+	}
+}
+
+func TestTemplateStrict(t *testing.T) {
+	tests := []struct {
+		tpl   map[string]any
+		err   error
+		error string
+	}{
+		{
+			tpl:   map[string]any{"list": map[string]any{"$iterrate": "items", "$body": "{{item}}"}},
+			err:   errUnknownDirective,
+			error: "/list/$iterrate: unknown directive $iterrate, did you mean $iterate?",
+		},
+		{
+			tpl:   map[string]any{"$if": "age > 18", "name": "{{name}}", "$els": map[string]any{"name": "teen"}},
+			err:   errUnknownDirective,
+			error: "/$els: unknown directive $els, did you mean $else?",
+		},
+		{
+			tpl:   map[string]any{"name": "{{name}}", "$else": "nobody"},
+			err:   errMisplacedDirective,
+			error: "/$else: misplaced directive $else without $if",
+		},
+		{
+			tpl:   map[string]any{"$iterate": map[string]any{"$expr": "items", "$wher": "item > 1", "$body": "{{item}}"}},
+			err:   errUnknownDirective,
+			error: "/$iterate/$wher: unknown directive $wher, did you mean $where?",
+		},
+		{
+			tpl:   map[string]any{"$ref": "#/definitions/item"},
+			err:   errUnknownDirective,
+			error: "/$ref: unknown directive $ref",
+		},
+	}
+	for _, test := range tests {
+		_, err := NewTemplateFor(test.tpl)
+		if assert.ErrorIs(t, err, test.err) {
+			assert.Equal(t, test.error, err.Error())
+		}
+	}
+
+	tmp, err := NewTemplateFor(map[string]any{"$ref": "#/definitions/{{name}}"}, WithStrict(false))
+	if assert.NoError(t, err) {
+		res, err := tmp.Process(context.Background(), map[string]any{"name": "item"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{"$ref": "#/definitions/item"}, res)
+	}
+}
+
+func TestTemplateCancel(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"items": map[string]any{"$iterate": "items", "$body": "{{process(item)}}"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	processed := 0
+	_, err = tpl.Process(ctx, map[string]any{
+		"items": tRange(1000),
+		"process": func(item any) any {
+			if processed++; processed == 3 {
+				cancel()
+			}
+			return item
+		},
+	})
+	var (
+		cancelErr *CanceledError
+		tplErr    *TemplateError
+	)
+	if assert.ErrorIs(t, err, context.Canceled) && assert.True(t, errors.As(err, &cancelErr)) {
+		assert.True(t, errors.As(err, &tplErr))
+		assert.Equal(t, "/items/$iterate", tplErr.Path)
+		assert.Equal(t, 3, processed)
+	}
+
+	_, err = tpl.Process(ctx, map[string]any{"items": []any{1}})
+	assert.True(t, errors.As(err, &cancelErr))
+}
+
+func TestTemplateTimeout(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+	tpl, err := NewTemplateFor(map[string]any{
+		"items": map[string]any{"$iterate": "items", "$body": "{{process(item)}}"},
+	}, WithTimeout(timeout))
+	if !assert.NoError(t, err) {
+		return
+	}
+	processed := 0
+	_, err = tpl.Process(context.Background(), map[string]any{
+		"items": tRange(1000),
+		"process": func(item any) any {
+			processed++
+			time.Sleep(timeout)
+			return item
+		},
+	})
+	var cancelErr *CanceledError
+	if assert.ErrorIs(t, err, context.DeadlineExceeded) && assert.True(t, errors.As(err, &cancelErr)) {
+		assert.Less(t, processed, 1000)
+	}
+
+	res, err := tpl.Process(context.Background(), map[string]any{
+		"items":   []any{1, 2},
+		"process": func(item any) any { return item },
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]any{"items": []any{1, 2}}, res)
+	}
+}
+
+func tRange(n int) []any {
+	items := make([]any, n)
+	for i := range items {
+		items[i] = i
+	}
+	return items
+}
@@ -0,0 +1,156 @@
+package datatemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/demdxx/gocast/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// FormatJSONPretty is the JSON output format with indentation
+const FormatJSONPretty Format = "json-pretty"
+
+var errInvalidTOMLRoot = errors.New("TOML document root must be a map")
+
+// ProcessTo processes the template with data and writes the result
+// into the writer encoded in the format (JSON, pretty JSON, YAML or TOML)
+func (tpl *Template) ProcessTo(ctx context.Context, w io.Writer, format Format, data map[string]any) error {
+	res, err := tpl.Process(ctx, data)
+	if err != nil {
+		return err
+	}
+	return encodeOutput(w, format, res)
+}
+
+func encodeOutput(w io.Writer, format Format, data any) error {
+	switch format {
+	case FormatJSON, FormatJSONPretty:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		if format == FormatJSONPretty {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(normalizeOutput(data, format))
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(normalizeOutput(data, format)); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatTOML:
+		data = normalizeOutput(data, format)
+		if _, ok := data.(map[string]any); !ok {
+			return errInvalidTOMLRoot
+		}
+		return toml.NewEncoder(w).Encode(data)
+	}
+	return errors.Wrap(errUnsupportedFormat, string(format))
+}
+
+// normalizeOutput converts values which have no native representation in the format:
+//   - []byte is encoded as base64 string for JSON and TOML, and as !!binary for YAML
+//   - time.Duration is encoded as a string like "1h30m0s"
+//   - time.Time is kept as is, as all formats support it natively
+//   - json.Number is converted to the number for YAML
+//   - encoding.TextMarshaler values like net.IP are kept as is, as all encoders use the text
+//   - json.Marshaler values like json.RawMessage are kept for JSON and decoded into plain values otherwise
+//   - *OrderedMap is converted to the regular map for TOML, which has no ordered tables
+//   - nil values are removed from TOML tables, which have no null value
+func normalizeOutput(data any, format Format) any {
+	switch v := data.(type) {
+	case nil:
+		return nil
+	case time.Time, *time.Time:
+		return v
+	case json.Number:
+		// YAML encoder writes the number as the quoted string
+		if format == FormatYAML {
+			if n, err := v.Int64(); err == nil {
+				return n
+			}
+			if f, err := v.Float64(); err == nil {
+				return f
+			}
+		}
+		return v
+	case time.Duration:
+		return v.String()
+	case []byte:
+		if format == FormatYAML {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!binary", Value: base64.StdEncoding.EncodeToString(v)}
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case *OrderedMap:
+		if format == FormatTOML {
+			return normalizeOutputMap(v.values, format)
+		}
+		res := NewOrderedMap()
+		for _, key := range v.keys {
+			res.Set(key, normalizeOutput(v.values[key], format))
+		}
+		return res
+	case map[string]any:
+		return normalizeOutputMap(v, format)
+	case []any:
+		res := make([]any, 0, len(v))
+		for _, item := range v {
+			// TOML has no null value, so nil items are dropped like nil map values
+			if item == nil && format == FormatTOML {
+				continue
+			}
+			res = append(res, normalizeOutput(item, format))
+		}
+		return res
+	case encoding.TextMarshaler:
+		return v
+	case json.Marshaler:
+		if format == FormatJSON || format == FormatJSONPretty {
+			return v
+		}
+		return normalizeJSONMarshaler(v, format)
+	}
+	switch reflect.ValueOf(data).Kind() {
+	case reflect.Map:
+		return normalizeOutputMap(gocast.Map[string, any](data), format)
+	case reflect.Slice, reflect.Array:
+		return normalizeOutput(gocast.AnySlice[any](data), format)
+	}
+	return data
+}
+
+// normalizeJSONMarshaler decodes the JSON of the value into plain values,
+// the value is kept as is if it can't be encoded so the encoder reports the error
+func normalizeJSONMarshaler(v json.Marshaler, format Format) any {
+	raw, err := v.MarshalJSON()
+	if err != nil {
+		return v
+	}
+	var res any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return v
+	}
+	return normalizeOutput(res, format)
+}
+
+func normalizeOutputMap(data map[string]any, format Format) map[string]any {
+	res := make(map[string]any, len(data))
+	for key, val := range data {
+		if val == nil && format == FormatTOML {
+			continue
+		}
+		res[key] = normalizeOutput(val, format)
+	}
+	return res
+}
@@ -0,0 +1,106 @@
+package datatemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateProcessTo(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader(`
+name: "{{name}}"
+created: "{{created}}"
+timeout: "{{timeout}}"
+cert: "{{cert}}"
+key: "{{key}}"
+ports:
+  $iterate: ports
+  $body: "{{item}}"
+`), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := map[string]any{
+		"name":    "web",
+		"created": time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		"timeout": 90 * time.Second,
+		"cert":    []byte("cert"),
+		"key":     []byte{0xff, 0xfe},
+		"ports":   []int{80, 443},
+	}
+	tests := []struct {
+		format Format
+		res    string
+	}{
+		{
+			format: FormatJSON,
+			res:    `{"name":"web","created":"2023-01-02T03:04:05Z","timeout":"1m30s","cert":"Y2VydA==","key":"//4=","ports":[80,443]}` + "\n",
+		},
+		{
+			format: FormatJSONPretty,
+			res:    "{\n  \"name\": \"web\",\n  \"created\": \"2023-01-02T03:04:05Z\",\n  \"timeout\": \"1m30s\",\n  \"cert\": \"Y2VydA==\",\n  \"key\": \"//4=\",\n  \"ports\": [\n    80,\n    443\n  ]\n}\n",
+		},
+		{
+			format: FormatYAML,
+			res:    "name: web\ncreated: 2023-01-02T03:04:05Z\ntimeout: 1m30s\ncert: !!binary Y2VydA==\nkey: !!binary //4=\nports:\n  - 80\n  - 443\n",
+		},
+		{
+			format: FormatTOML,
+			res:    "cert = \"Y2VydA==\"\ncreated = 2023-01-02T03:04:05Z\nkey = \"//4=\"\nname = \"web\"\nports = [80, 443]\ntimeout = \"1m30s\"\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if assert.NoError(t, tpl.ProcessTo(context.Background(), &buf, test.format, data)) {
+				assert.Equal(t, test.res, buf.String())
+			}
+		})
+	}
+
+	t.Run("marshalers", func(t *testing.T) {
+		tpl, err := NewTemplateFor(map[string]any{"ip": "{{ip}}", "raw": "{{raw}}"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		data := map[string]any{"ip": net.IPv4(10, 0, 0, 1), "raw": json.RawMessage(`{"port":80}`)}
+		tests := map[Format]string{
+			FormatJSON: `{"ip":"10.0.0.1","raw":{"port":80}}` + "\n",
+			FormatYAML: "ip: 10.0.0.1\nraw:\n  port: 80\n",
+			FormatTOML: "ip = \"10.0.0.1\"\n\n[raw]\n  port = 80\n",
+		}
+		for format, res := range tests {
+			var buf bytes.Buffer
+			if assert.NoError(t, tpl.ProcessTo(context.Background(), &buf, format, data), format) {
+				assert.Equal(t, res, buf.String(), format)
+			}
+		}
+	})
+
+	t.Run("toml-nil", func(t *testing.T) {
+		tpl, err := NewTemplateFor(map[string]any{"hosts": []any{"a", "{{missing}}", "b"}, "empty": "{{missing}}"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		var buf bytes.Buffer
+		if assert.NoError(t, tpl.ProcessTo(context.Background(), &buf, FormatTOML, data)) {
+			assert.Equal(t, "hosts = [\"a\", \"b\"]\n", buf.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tpl, err := NewTemplateFor("{{name}}")
+		if !assert.NoError(t, err) {
+			return
+		}
+		var buf bytes.Buffer
+		assert.ErrorIs(t, tpl.ProcessTo(context.Background(), &buf, FormatTOML, data), errInvalidTOMLRoot)
+		assert.ErrorIs(t, tpl.ProcessTo(context.Background(), &buf, Format("xml"), data), errUnsupportedFormat)
+	})
+}
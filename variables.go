@@ -0,0 +1,325 @@
+package datatemplate
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antonmedv/expr/ast"
+)
+
+// Variables returns sorted paths of the input data referenced by the template, like `name` or `person[].age`,
+// where `[]` is any item of the list or map. Variables of the template scope like `item`, `index`,
+// `$with` variables and macro parameters are not the input data, so loop items and aliases are resolved
+// to the paths of their values and other scope variables are excluded.
+func (tpl *Template) Variables() []string {
+	c := &varsCollector{paths: map[string]bool{}, macros: map[*Macro]bool{}}
+	c.block(tpl.root, nil)
+	return c.result()
+}
+
+// varsScope maps names of scope variables to data paths of their values,
+// the empty path means the variable is not the input data
+type varsScope map[string]string
+
+func (s varsScope) with(name, path string) varsScope {
+	scope := make(varsScope, len(s)+1)
+	for k, v := range s {
+		scope[k] = v
+	}
+	scope[name] = path
+	return scope
+}
+
+// elementBuiltins return items of the list passed as the first argument
+var elementBuiltins = map[string]bool{"filter": true, "find": true, "findLast": true, "sortBy": true, "groupBy": true}
+
+type varsCollector struct {
+	// paths of the data, the value is false for paths used only as aliases of scope variables,
+	// like `person` in `$iterate: person`, they are reported only if no other path extends them
+	paths map[string]bool
+
+	// macros which bodies are collected at the moment
+	macros map[*Macro]bool
+}
+
+func (c *varsCollector) add(path string, used bool) {
+	if path != "" {
+		c.paths[path] = c.paths[path] || used
+	}
+}
+
+func (c *varsCollector) result() []string {
+	res := make([]string, 0, len(c.paths))
+	for path, used := range c.paths {
+		if !used && c.extended(path) {
+			continue
+		}
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// extended returns true if any other path starts with the path
+func (c *varsCollector) extended(path string) bool {
+	for other := range c.paths {
+		if len(other) > len(path) && strings.HasPrefix(other, path) && (other[len(path)] == '.' || other[len(path)] == '[') {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *varsCollector) block(block Block, scope varsScope) {
+	switch b := block.(type) {
+	case *DataBlockSlice:
+		for _, item := range b.data {
+			if bl, ok := item.(Block); ok {
+				c.block(bl, scope)
+			}
+		}
+	case *DataBlockMap:
+		for _, item := range b.data {
+			if bl, ok := item.(Block); ok {
+				c.block(bl, scope)
+			}
+		}
+	case *ExprBlock:
+		c.program(b.expr, scope)
+	case *ExprBlockStringTmplate:
+		for _, program := range b.exprs {
+			c.program(program, scope)
+		}
+	case *IfBlock:
+		c.program(b.cond, scope)
+		c.block(b.thenBlock, scope)
+		c.block(b.elseBlock, scope)
+	case *IterateBlock:
+		itemPath := c.alias(b.expr, scope)
+		if itemPath != "" {
+			itemPath += "[]"
+		}
+		c.program(b.limitExpr, scope)
+		c.program(b.offsetExpr, scope)
+		loopScope := scope.with(b.indexName, "").with(b.keyName, "").with(b.valueName, itemPath)
+		c.program(b.where, loopScope)
+		c.program(b.sortBy, loopScope)
+		c.block(b.outKey, loopScope)
+		c.block(b.block, loopScope)
+	case *WithBlock:
+		for _, v := range b.vars {
+			scope = scope.with(v.Name, c.alias(v.Expr, scope))
+		}
+		c.block(b.body, scope)
+	case *SwitchBlock:
+		subject := c.alias(b.expr, scope)
+		if b.name != "" {
+			scope = scope.with(b.name, subject)
+		}
+		for _, cs := range b.cases {
+			for _, value := range cs.Values {
+				if bl, ok := value.(Block); ok {
+					c.block(bl, scope)
+				}
+			}
+			c.program(cs.Cond, scope)
+			c.block(cs.Body, scope)
+		}
+		c.block(b.defaultBlock, scope)
+	case *SpreadBlock:
+		c.block(b.block, scope)
+	case *IncludeBlock:
+		bodyScope := scope
+		for _, v := range b.vars {
+			c.block(v.Value, scope)
+			bodyScope = bodyScope.with(v.Name, "")
+		}
+		c.block(b.body, bodyScope)
+	case *CallBlock:
+		for _, arg := range b.args {
+			c.block(arg, scope)
+		}
+		// The macro body sees the scope of the call with parameters on top of it,
+		// recursive calls are skipped as the body is already collected in the same scope
+		if !c.macros[b.macro] {
+			c.macros[b.macro] = true
+			macroScope := scope
+			for _, param := range b.macro.Params {
+				macroScope = macroScope.with(param, "")
+			}
+			c.block(b.macro.Body, macroScope)
+			delete(c.macros, b.macro)
+		}
+	case *directiveBlock:
+		c.block(b.Block, scope)
+	}
+}
+
+func (c *varsCollector) program(program *Program, scope varsScope) {
+	if program != nil && program.Node != nil {
+		c.node(program.Node, scope, "")
+	}
+}
+
+// alias collects paths of the expression which value is bound to the scope variable
+// and returns the path of the value if the expression is the path like `person[0]`
+func (c *varsCollector) alias(program *Program, scope varsScope) string {
+	if program == nil || program.Node == nil {
+		return ""
+	}
+	if path, ok := c.path(program.Node, scope, ""); ok {
+		c.add(path, false)
+		return path
+	}
+	c.node(program.Node, scope, "")
+	return ""
+}
+
+// node collects paths of the expression node, elem is the path of the closure item `#`
+func (c *varsCollector) node(node ast.Node, scope varsScope, elem string) {
+	if path, ok := c.path(node, scope, elem); ok {
+		c.add(path, true)
+		return
+	}
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		c.node(n.Node, scope, elem)
+	case *ast.BinaryNode:
+		c.node(n.Left, scope, elem)
+		c.node(n.Right, scope, elem)
+	case *ast.ChainNode:
+		c.node(n.Node, scope, elem)
+	case *ast.MemberNode:
+		c.node(n.Node, scope, elem)
+		c.node(n.Property, scope, elem)
+	case *ast.SliceNode:
+		c.node(n.Node, scope, elem)
+		c.nodes(scope, elem, n.From, n.To)
+	case *ast.CallNode:
+		// Registered functions are not the data
+		if n.Func == nil {
+			c.node(n.Callee, scope, elem)
+		}
+		c.nodes(scope, elem, n.Arguments...)
+	case *ast.BuiltinNode:
+		c.builtin(n, scope, elem)
+	case *ast.ClosureNode:
+		c.node(n.Node, scope, elem)
+	case *ast.ConditionalNode:
+		c.nodes(scope, elem, n.Cond, n.Exp1, n.Exp2)
+	case *ast.VariableDeclaratorNode:
+		path, ok := c.path(n.Value, scope, elem)
+		if ok {
+			c.add(path, false)
+		} else {
+			c.node(n.Value, scope, elem)
+		}
+		c.node(n.Expr, scope.with(n.Name, path), elem)
+	case *ast.ArrayNode:
+		c.nodes(scope, elem, n.Nodes...)
+	case *ast.MapNode:
+		c.nodes(scope, elem, n.Pairs...)
+	case *ast.PairNode:
+		c.nodes(scope, elem, n.Key, n.Value)
+	}
+}
+
+func (c *varsCollector) nodes(scope varsScope, elem string, nodes ...ast.Node) {
+	for _, node := range nodes {
+		if node != nil {
+			c.node(node, scope, elem)
+		}
+	}
+}
+
+// builtin collects paths of builtins like `filter(person, .age > 18)`,
+// closures see items of the list passed as the first argument
+func (c *varsCollector) builtin(n *ast.BuiltinNode, scope varsScope, elem string) {
+	hasClosure := false
+	for _, arg := range n.Arguments {
+		if _, ok := arg.(*ast.ClosureNode); ok {
+			hasClosure = true
+		}
+	}
+	if !hasClosure || len(n.Arguments) == 0 {
+		c.nodes(scope, elem, n.Arguments...)
+		return
+	}
+	itemPath, ok := c.path(n.Arguments[0], scope, elem)
+	if ok {
+		c.add(itemPath, elementBuiltins[n.Name])
+		if itemPath != "" {
+			itemPath += "[]"
+		}
+	} else {
+		c.node(n.Arguments[0], scope, elem)
+	}
+	for _, arg := range n.Arguments[1:] {
+		if closure, ok := arg.(*ast.ClosureNode); ok {
+			c.node(closure.Node, scope, itemPath)
+		} else {
+			c.node(arg, scope, elem)
+		}
+	}
+}
+
+// path returns the data path of the node and true if the node is the path expression like `person[0].name`,
+// the empty path is returned for scope variables which are not the input data.
+// Paths of dynamic indexes like `labels[key]` are collected as well.
+func (c *varsCollector) path(node ast.Node, scope varsScope, elem string) (string, bool) {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		if n.Value == "$env" {
+			return "", false
+		}
+		if path, ok := scope[n.Value]; ok {
+			return path, true
+		}
+		return n.Value, true
+	case *ast.PointerNode:
+		if n.Name == "" {
+			return elem, true
+		}
+		return "", true
+	case *ast.ChainNode:
+		return c.path(n.Node, scope, elem)
+	case *ast.MemberNode:
+		if ident, ok := n.Node.(*ast.IdentifierNode); ok && ident.Value == "$env" {
+			if prop, ok := n.Property.(*ast.StringNode); ok {
+				if prop.Value == ctxDataKey {
+					return "", true
+				}
+				return prop.Value, true
+			}
+			c.node(n.Property, scope, elem)
+			return "", true
+		}
+		base, ok := c.path(n.Node, scope, elem)
+		if !ok {
+			return "", false
+		}
+		switch prop := n.Property.(type) {
+		case *ast.StringNode:
+			return joinVarPath(base, "."+prop.Value), true
+		case *ast.IntegerNode:
+		default:
+			c.node(n.Property, scope, elem)
+		}
+		return joinVarPath(base, "[]"), true
+	case *ast.SliceNode:
+		base, ok := c.path(n.Node, scope, elem)
+		if !ok {
+			return "", false
+		}
+		c.nodes(scope, elem, n.From, n.To)
+		return joinVarPath(base, "[]"), true
+	}
+	return "", false
+}
+
+func joinVarPath(base, suffix string) string {
+	if base == "" {
+		return ""
+	}
+	return base + suffix
+}
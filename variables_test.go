@@ -0,0 +1,101 @@
+package datatemplate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader(`
+$define:
+  - name: port
+    params: [name, number]
+    body:
+      name: "{{name}}"
+      containerPort: "{{number}}"
+      app: "{{app}}"
+name: "{{upper(name)}}"
+host: "{{parseURI(database.uri).host}}"
+people:
+  $iterate: person
+  $where: item.age > 18
+  $body:
+    name: "{{item.name}}-{{index}}"
+    tags:
+      $iterate: tag, i := item.tags
+      $body: "{{tag}}:{{i}}"
+labels:
+  $iterate: labels
+  $body: "{{key}}={{item}}"
+groups: "{{len(groups)}}"
+adults: "{{count(employees, .age > 18)}}"
+admins: "{{filter(users, .admin)}}"
+first:
+  $switch: p := team[0]
+  $case:
+    - $cond: p.lead
+      $body: "{{p.name}}"
+count:
+  $with: [n := len(items), m := n * 2]
+  $body: "{{m + 1}}"
+env: "{{env[region].zone}}"
+ports:
+  - $call: port
+    $args: [http, "{{httpPort}}"]
+`), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{
+		"app", "database.uri", "employees[].age", "env[].zone", "groups", "httpPort", "items",
+		"labels[]", "name", "person[].age", "person[].name", "person[].tags[]", "region", "team[].lead",
+		"team[].name", "users", "users[].admin",
+	}, tpl.Variables())
+}
+
+func TestTemplateVariablesAlias(t *testing.T) {
+	tpl, err := NewTemplateFor(map[string]any{
+		"all":   map[string]any{"$iterate": "servers", "$body": "static"},
+		"first": map[string]any{"$with": "s := servers[0]", "$body": "{{s}}"},
+		"names": "{{map(servers, .name)}}",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// `servers` is not reported as only its items and names are used
+	assert.Equal(t, []string{"servers[]", "servers[].name"}, tpl.Variables())
+}
+
+func TestTemplateVariablesMacro(t *testing.T) {
+	tpl, err := ParseReader(strings.NewReader(`
+$define:
+  - name: person
+    params: [prefix]
+    body: "{{prefix}}{{item.name}}"
+  - name: tree
+    params: [node]
+    body:
+      name: "{{node.name}}"
+      children:
+        $iterate: node.children
+        $body:
+          $call: tree
+          $args: ["{{item}}"]
+people:
+  $iterate: people
+  $body:
+    age: "{{item.age}}"
+    name:
+      $call: person
+      $args: ["{{title}}"]
+tree:
+  $call: tree
+  $args: {node: "{{root}}"}
+`), FormatYAML)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"people[].age", "people[].name", "root", "title"}, tpl.Variables())
+}
@@ -2,18 +2,35 @@ package datatemplate
 
 import (
 	"context"
+	"strings"
 
 	"github.com/demdxx/xtypes"
 )
 
+// WithVar is the variable of the with block scope
+type WithVar struct {
+	Name string
+	Expr *Program
+}
+
+func (v *WithVar) String() string {
+	return "`" + v.Name + " := " + v.Expr.Source.Content() + "`"
+}
+
+// WithBlock binds variables into the new scope of the body,
+// variables are evaluated in order and every next one sees the previous ones
 type WithBlock struct {
-	name string
-	expr *Program
+	blockSource
+	vars []*WithVar
 	body Block
 }
 
 func NewWithBlock(name string, expr *Program, body Block) *WithBlock {
-	return &WithBlock{name: name, expr: expr, body: body}
+	return NewWithBlockVars([]*WithVar{{Name: name, Expr: expr}}, body)
+}
+
+func NewWithBlockVars(vars []*WithVar, body Block) *WithBlock {
+	return &WithBlock{vars: vars, body: body}
 }
 
 func NewWithBlockFromExpr(ctx context.Context, name, expr string, body Block) (Block, error) {
@@ -25,14 +42,32 @@ func NewWithBlockFromExpr(ctx context.Context, name, expr string, body Block) (B
 }
 
 func (wi *WithBlock) String() string {
-	return "$with: {`$expr`: `" + wi.name + " := " + wi.expr.Source.Content() + "`, $body: " + wi.body.String() + "}"
+	var buf strings.Builder
+	buf.WriteString("$with: {`$expr`: ")
+	if len(wi.vars) == 1 {
+		buf.WriteString(wi.vars[0].String())
+	} else {
+		buf.WriteString("[")
+		for i, v := range wi.vars {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(v.String())
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString(", $body: " + wi.body.String() + "}")
+	return buf.String()
 }
 
 func (wi *WithBlock) Emit(ctx context.Context, data map[string]any) (any, error) {
-	res, err := runExpr(ctx, wi.expr, data)
-	if err != nil {
-		return nil, err
+	newData := xtypes.Map[string, any](data).Copy()
+	for _, v := range wi.vars {
+		res, err := runExpr(ctx, v.Expr, newData)
+		if err != nil {
+			return nil, wi.wrapError(err)
+		}
+		newData[v.Name] = res
 	}
-	newData := xtypes.Map[string, any](data).Copy().Set(wi.name, res)
 	return wi.body.Emit(ctx, newData)
 }
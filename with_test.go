@@ -30,3 +30,77 @@ func TestWithBlock(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "tony", res)
 }
+
+func TestWithBlockVars(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		tpl map[string]any
+		str string
+	}{
+		{
+			tpl: map[string]any{
+				"$with": []any{"host := service.host", "port := service.port ?? 80", "addr := host + ':' + string(port)"},
+				"$body": "{{addr}}",
+			},
+			str: "$with: {`$expr`: [`host := service.host`, `port := service.port ?? 80`, `addr := host + ':' + string(port)`], $body: `addr`}",
+		},
+		{
+			tpl: map[string]any{
+				"$with": map[string]any{
+					"$expr": []any{"host := service.host", "port := service.port ?? 80", "addr := host + ':' + string(port)"},
+					"addr":  "{{addr}}",
+				},
+			},
+		},
+		{
+			tpl: map[string]any{
+				"$let": NewOrderedMap().
+					Set("host", "service.host").
+					Set("port", "service.port ?? 80").
+					Set("addr", "host + ':' + string(port)"),
+				"addr": "{{addr}}",
+			},
+		},
+		{
+			tpl: map[string]any{
+				"$let": map[string]any{
+					"port": "service.port ?? 80",
+					"addr": "host + ':' + string(port)",
+					"host": "service.host",
+				},
+				"addr": "{{addr}}",
+			},
+		},
+		{
+			tpl: map[string]any{
+				"$let":  []any{"host := service.host", "port := service.port ?? 80", "addr := host + ':' + string(port)"},
+				"$body": "{{addr}}",
+			},
+		},
+	}
+	for _, test := range tests {
+		tpl, err := NewTemplateFor(test.tpl)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		if test.str != "" {
+			assert.Equal(t, test.str, tpl.String())
+		}
+		res, err := tpl.Process(ctx, map[string]any{"service": map[string]any{"host": "web"}})
+		if assert.NoError(t, err) {
+			if mp, ok := res.(map[string]any); ok {
+				res = mp["addr"]
+			}
+			assert.Equal(t, "web:80", res)
+		}
+	}
+
+	_, err := NewTemplateFor(map[string]any{"$with": []any{"host := service.host", "port = 80"}, "$body": 1})
+	assert.ErrorIs(t, err, errInvalidWithBlockExpr)
+	_, err = NewTemplateFor(map[string]any{"$let": "host := service.host", "$body": 1})
+	assert.ErrorIs(t, err, errInvalidWithBlockExpr)
+	_, err = NewTemplateFor(map[string]any{"$let": map[string]any{"a": "b + 1", "b": "a + 1"}, "$body": 1})
+	if assert.ErrorIs(t, err, errInvalidWithBlockExpr) {
+		assert.Contains(t, err.Error(), "cyclic reference of variable")
+	}
+}